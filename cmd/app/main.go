@@ -8,119 +8,213 @@ import (
 	"github.com/rs/cors"
 
 	"gosmol/internal/adapters/rest"
+	"gosmol/internal/apperror"
 	"gosmol/internal/config"
 	"gosmol/internal/service"
 	"gosmol/internal/storage/psql"
 
+	"gosmol/pkg/auth"
 	"gosmol/pkg/client/postgresql"
+	"gosmol/pkg/cursor"
+	"gosmol/pkg/email"
+	"gosmol/pkg/filestorage"
 	"gosmol/pkg/logging"
 )
 
 const (
-    envLocal = "local"
-    envDev   = "dev"
-    envProd  = "prod"
+	envLocal = "local"
+	envDev   = "dev"
+	envProd  = "prod"
 )
 
 func main() {
-  logging.Init()
-
-  logger := logging.GetLogger()
-  logger.Infoln("Logger enabled")
-
-  jwtSecret := "my-secret-key"
-  logger.Infof("secret %s", jwtSecret)
-
-  logger.Infoln("Config initializing")
-
-  router := httprouter.New()
-  logger.Infoln("Router initializing")
-
-  cfg := config.GetConfig()
-  logger.Infof("DB CONFIG: Host=%s, Port=%d, Database=%s, Username=%s", 
-    cfg.StorageConfig.Host, cfg.StorageConfig.Port, 
-    cfg.StorageConfig.Database, cfg.StorageConfig.Username)
-  logger.Infoln("Config initializing")
-
-  postgreSQLClient, err := postgresql.NewClient(context.TODO(), 15, cfg.StorageConfig)
-  if err != nil {
-    logger.Fatalf("Failed to connect to database: %v", err)
-  }
-
-  logger.Infoln("Checking available databases...")
-    rows, err := postgreSQLClient.Query(context.Background(), "SELECT datname FROM pg_database WHERE datistemplate = false;")
-    if err == nil {
-        defer rows.Close()
-        for rows.Next() {
-            var dbName string
-            rows.Scan(&dbName)
-            logger.Infof("Available database: %s", dbName)
-        }
-    }
-
-    logger.Infoln("Checking tables in current database...")
-    rows, err = postgreSQLClient.Query(context.Background(), 
-        "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public';")
-    if err == nil {
-        defer rows.Close()
-        for rows.Next() {
-            var tableName string
-            rows.Scan(&tableName)
-            logger.Infof("Table: %s", tableName)
-        }
-    }
-
-    var actualUserCount int
-    err = postgreSQLClient.QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&actualUserCount)
-    if err != nil {
-        logger.Errorf("Count users failed: %v", err)
-    } else {
-        logger.Infof("Actual users in connected DB: %d", actualUserCount)
-        
-        rows, err := postgreSQLClient.Query(context.Background(), "SELECT id, email FROM users LIMIT 5")
-        if err == nil {
-            defer rows.Close()
-            for rows.Next() {
-                var id int64
-                var email string
-                rows.Scan(&id, &email)
-                logger.Infof("   User: ID=%d, Email=%s", id, email)
-            }
-        }
-    }
-
-  logger.Infoln("Database initializing")
-
-  twoFaRepo := psql.NewTwoFaRepo(postgreSQLClient)
-  studentsRepo := psql.NewStudentsRepo(postgreSQLClient)
-  studentsService := service.NewStudents(studentsRepo, twoFaRepo, jwtSecret)
-  studentsHandler := rest.NewStudentsHandler(studentsService, logger)
-  studentsHandler.Register(router, jwtSecret)
-
-  diplomasRepo := psql.NewDiplomasRepo(postgreSQLClient)
-  diplomasService := service.NewDiplomas(diplomasRepo)
-  diplomasHandler := rest.NewDiplomasHandler(diplomasService, logger)
-  diplomasHandler.Register(router, jwtSecret)
-
-  logger.Infoln("📋 Registered routes:")
-  router.HandleOPTIONS = true
-
-  logger.Infof("Students routes: /api/auth/register, /api/auth/login, /api/auth/refresh")
-  logger.Infof("Diplomas routes: /api/resources, /api/resource/:id")
-
-  logger.Infoln("Students & diplomas initializing")
-
-  cors := cors.New(cors.Options{
-    AllowedOrigins:   []string{"*"},
-    AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
-    AllowedHeaders:   []string{"*"},
-    ExposedHeaders:   []string{"*"},
-    AllowCredentials: true,
-    MaxAge:           12 * 3600,
-  })
-
-  handler := cors.Handler(router)
-  logger.Infoln("Cors initializing")
-
-  logger.Fatalln(http.ListenAndServe(":8888", handler))
-}
\ No newline at end of file
+	logging.Init()
+
+	logger := logging.GetLogger()
+	logger.Infoln("Logger enabled")
+
+	logger.Infoln("Config initializing")
+
+	router := httprouter.New()
+	logger.Infoln("Router initializing")
+
+	cfg := config.GetConfig()
+	logger.Infof("DB CONFIG: Host=%s, Port=%s, Database=%s, Username=%s",
+		cfg.StorageConfig.Host, cfg.StorageConfig.Port,
+		cfg.StorageConfig.Database, cfg.StorageConfig.Username)
+	logger.Infoln("Config initializing")
+
+	postgreSQLClient, err := postgresql.NewClient(context.TODO(), 15, cfg.StorageConfig)
+	if err != nil {
+		logger.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	logger.Infoln("Checking available databases...")
+	rows, err := postgreSQLClient.Query(context.Background(), "SELECT datname FROM pg_database WHERE datistemplate = false;")
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var dbName string
+			rows.Scan(&dbName)
+			logger.Infof("Available database: %s", dbName)
+		}
+	}
+
+	logger.Infoln("Checking tables in current database...")
+	rows, err = postgreSQLClient.Query(context.Background(),
+		"SELECT table_name FROM information_schema.tables WHERE table_schema = 'public';")
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var tableName string
+			rows.Scan(&tableName)
+			logger.Infof("Table: %s", tableName)
+		}
+	}
+
+	var actualUserCount int
+	err = postgreSQLClient.QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&actualUserCount)
+	if err != nil {
+		logger.Errorf("Count users failed: %v", err)
+	} else {
+		logger.Infof("Actual users in connected DB: %d", actualUserCount)
+
+		rows, err := postgreSQLClient.Query(context.Background(), "SELECT id, email FROM users LIMIT 5")
+		if err == nil {
+			defer rows.Close()
+			for rows.Next() {
+				var id int64
+				var email string
+				rows.Scan(&id, &email)
+				logger.Infof("   User: ID=%d, Email=%s", id, email)
+			}
+		}
+	}
+
+	logger.Infoln("Database initializing")
+
+	keys, err := auth.LoadKeySet(cfg.Auth.KeysDir, cfg.Auth.Algorithm, cfg.Auth.KeyRetention)
+	if err != nil {
+		logger.Fatalf("Failed to load signing keys: %v", err)
+	}
+	go keys.RunRotation(context.Background(), cfg.Auth.RotationInterval)
+	logger.Infof("Signing keys loaded from %s, algorithm %s", cfg.Auth.KeysDir, cfg.Auth.Algorithm)
+
+	emailSender, err := email.NewSender(email.Config{
+		Driver:     cfg.Email.Driver,
+		From:       cfg.Email.From,
+		MailboxDir: cfg.Email.MailboxDir,
+		Host:       cfg.Email.Host,
+		Port:       cfg.Email.Port,
+		Username:   cfg.Email.Username,
+		Password:   cfg.Email.Password,
+		Encryption: cfg.Email.Encryption,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to initialize email sender: %v", err)
+	}
+	logger.Infof("Email driver: %s", cfg.Email.Driver)
+
+	twoFaRepo := psql.NewTwoFaRepo(postgreSQLClient)
+	studentsRepo := psql.NewStudentsRepo(postgreSQLClient)
+	verifier := apperror.NewVerifier(keys, studentsRepo, cfg.Auth.Issuer, cfg.Auth.Audience)
+
+	studentsService := service.NewStudents(studentsRepo, twoFaRepo, keys, service.StudentsOptions{
+		PublicURL:                cfg.PublicURL,
+		RequireEmailVerification: cfg.Auth.RequireEmailVerification,
+		Issuer:                   cfg.Auth.Issuer,
+		Audience:                 cfg.Auth.Audience,
+	})
+	trustedProxies, err := rest.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		logger.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+	studentsHandler := rest.NewStudentsHandler(studentsService, logger, trustedProxies)
+	studentsHandler.Register(router, verifier)
+
+	diplomasRepo := psql.NewDiplomasRepo(postgreSQLClient)
+	cursors := cursor.NewSigner([]byte(cfg.Listing.CursorSecret))
+	if cfg.Listing.CursorSecret == "" {
+		cursors, err = cursor.NewRandomSigner()
+		if err != nil {
+			logger.Fatalf("Failed to create cursor signer: %v", err)
+		}
+		logger.Warnln("CURSOR_SECRET is not set, pagination cursors will not survive a restart")
+	}
+	diplomasService := service.NewDiplomas(diplomasRepo, cursors)
+	diplomasHandler := rest.NewDiplomasHandler(diplomasService, logger)
+	diplomasHandler.Register(router, verifier)
+
+	fileStorage, err := filestorage.New(filestorage.Config{
+		Driver:    cfg.Files.Driver,
+		Dir:       cfg.Files.Dir,
+		Endpoint:  cfg.Files.Endpoint,
+		Bucket:    cfg.Files.Bucket,
+		AccessKey: cfg.Files.AccessKey,
+		SecretKey: cfg.Files.SecretKey,
+		Region:    cfg.Files.Region,
+		UseSSL:    cfg.Files.UseSSL,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to initialize file storage: %v", err)
+	}
+	logger.Infof("File storage driver: %s", cfg.Files.Driver)
+
+	filesRepo := psql.NewFilesRepo(postgreSQLClient)
+	filesService := service.NewFiles(filesRepo, diplomasRepo, fileStorage, service.FilesOptions{
+		MaxSize:      cfg.Files.MaxSize,
+		AllowedTypes: cfg.Files.AllowedTypes,
+	})
+	filesHandler := rest.NewFilesHandler(filesService, logger)
+	filesHandler.Register(router, verifier)
+
+	trashService := service.NewTrash(diplomasRepo, fileStorage, service.TrashOptions{
+		Retention:     cfg.Trash.Retention,
+		PurgeInterval: cfg.Trash.PurgeInterval,
+	})
+
+	outboxRepo := psql.NewOutboxRepo(postgreSQLClient)
+	outboxService := service.NewOutbox(outboxRepo, emailSender, service.OutboxOptions{
+		PollInterval: cfg.Outbox.PollInterval,
+		BatchSize:    cfg.Outbox.BatchSize,
+		BaseBackoff:  cfg.Outbox.BaseBackoff,
+		MaxBackoff:   cfg.Outbox.MaxBackoff,
+	})
+	outboxHandler := rest.NewOutboxHandler(outboxService, logger)
+	outboxHandler.Register(router, verifier)
+
+	keysHandler := rest.NewKeysHandler(keys, logger)
+	keysHandler.Register(router)
+
+	go outboxService.Run(context.Background())
+	logger.Infof("Outbox dispatcher started, poll interval %s", cfg.Outbox.PollInterval)
+
+	go trashService.Run(context.Background())
+	logger.Infof("Trash purge started, retention %s", cfg.Trash.Retention)
+
+	logger.Infoln("📋 Registered routes:")
+	router.HandleOPTIONS = true
+
+	logger.Infof("Students routes: /api/auth/register, /api/auth/login, /api/auth/refresh, /api/auth/password/forgot, /api/auth/password/reset, /api/auth/sessions, /api/auth/logout, /api/auth/logout-all")
+	logger.Infof("Diplomas routes: /api/resources, /api/resources/export, /api/resource/:id, /api/resource/:id/{submit,review,approve,reject,defend,history,supervision-requests,reviews,revisions,diff}, /api/resource/:id/reviews/finalize, /api/resource/:id/revisions/:version/revert, /api/students/me/diplomas")
+	logger.Infof("Files routes: /api/resource/:id/files, /api/resource/:id/files/:fileId")
+	logger.Infof("Supervision routes: /api/supervisors, /api/supervisors/me/requests, /api/supervisors/me/diplomas, /api/supervision-requests/:id/{accept,decline}, /api/admin/supervisors/:id/capacity")
+	logger.Infof("Admin routes: /api/admin/outbox, /api/admin/outbox/:id/retry, /api/admin/users/:id/role, /api/admin/resources/trash, /api/resource/:id/restore")
+	logger.Infof("Keys routes: /.well-known/jwks.json")
+
+	logger.Infoln("Students & diplomas initializing")
+
+	cors := cors.New(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
+		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{"*"},
+		AllowCredentials: true,
+		MaxAge:           12 * 3600,
+	})
+
+	handler := cors.Handler(router)
+	logger.Infoln("Cors initializing")
+
+	logger.Fatalln(http.ListenAndServe(":8888", handler))
+}
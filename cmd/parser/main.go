@@ -1,7 +1,76 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
 
+	"gosmol/internal/config"
+	"gosmol/internal/domain"
+	"gosmol/internal/service"
+	"gosmol/internal/storage/psql"
+
+	"gosmol/pkg/client/postgresql"
+	"gosmol/pkg/logging"
+)
+
+// parser imports diploma topics from a CSV or XLSX file with the columns
+// title, description, student_email and supervisor:
+//
+//	parser [-dry-run] [-sheet name] topics.xlsx
+//
+// All rows are validated first and imported in one transaction; any invalid
+// row rejects the whole file.
 func main() {
-	fmt.Println("Parser is working!")
-}
\ No newline at end of file
+	dryRun := flag.Bool("dry-run", false, "validate and insert the rows, then roll back")
+	sheet := flag.String("sheet", "", "XLSX sheet to read, the active sheet by default")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-dry-run] [-sheet name] file.csv|file.xlsx\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rows, err := readRows(flag.Arg(0), *sheet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read %s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	logging.Init()
+	logger := logging.GetLogger()
+	cfg := config.GetConfig()
+
+	postgreSQLClient, err := postgresql.NewClient(context.TODO(), 5, cfg.StorageConfig)
+	if err != nil {
+		logger.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer postgreSQLClient.Close()
+
+	importer := service.NewImporter(psql.NewDiplomasRepo(postgreSQLClient))
+	report, err := importer.ImportResources(rows, *dryRun)
+
+	for _, rowErr := range report.Errors {
+		fmt.Fprintln(os.Stderr, rowErr.Error())
+	}
+	if err != nil {
+		if errors.Is(err, domain.ErrImportRejected) {
+			fmt.Fprintf(os.Stderr, "%d of %d rows are invalid, nothing was imported\n", len(report.Errors), report.Rows)
+		} else {
+			fmt.Fprintf(os.Stderr, "Import failed, nothing was imported: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run: %d rows are valid, nothing was imported\n", report.Imported)
+		return
+	}
+	fmt.Printf("Imported %d diplomas: %v\n", report.Imported, report.IDs)
+}
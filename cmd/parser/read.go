@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+
+	"gosmol/internal/domain"
+)
+
+// columnAliases maps the accepted header names to the import fields.
+var columnAliases = map[string]string{
+	"title":            "title",
+	"topic":            "title",
+	"description":      "description",
+	"student_email":    "student_email",
+	"student":          "student_email",
+	"email":            "student_email",
+	"supervisor":       "supervisor",
+	"supervisor_email": "supervisor",
+}
+
+// readRows reads an import file. The format follows the file extension and
+// the first row must be a header naming the columns.
+func readRows(path, sheet string) ([]domain.ImportRow, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return readCSV(path)
+	case ".xlsx":
+		return readXLSX(path, sheet)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, expected .csv or .xlsx", filepath.Ext(path))
+	}
+}
+
+func readCSV(path string) ([]domain.ImportRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var records [][]string
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		records = append(records, record)
+		lines = append(lines, line)
+	}
+
+	if len(records) > 0 && len(records[0]) > 0 {
+		records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	}
+	return mapRows(records, lines)
+}
+
+func readXLSX(path, sheet string) ([]domain.ImportRow, error) {
+	file, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if sheet == "" {
+		sheet = file.GetSheetName(file.GetActiveSheetIndex())
+	}
+	records, err := file.GetRows(sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]int, len(records))
+	for i := range records {
+		lines[i] = i + 1
+	}
+	return mapRows(records, lines)
+}
+
+// mapRows turns records into import rows by their header. Blank records are
+// skipped; lines holds the file line of every record.
+func mapRows(records [][]string, lines []int) ([]domain.ImportRow, error) {
+	if len(records) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		field, ok := columnAliases[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", records[0][i])
+		}
+		if _, dup := columns[field]; dup {
+			return nil, fmt.Errorf("column %q appears twice", field)
+		}
+		columns[field] = i
+	}
+	for _, field := range []string{"title", "student_email"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("missing column %q", field)
+		}
+	}
+
+	cell := func(record []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []domain.ImportRow
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		rows = append(rows, domain.ImportRow{
+			Line:            lines[i+1],
+			Title:           cell(record, "title"),
+			Description:     cell(record, "description"),
+			StudentEmail:    cell(record, "student_email"),
+			SupervisorEmail: cell(record, "supervisor"),
+		})
+	}
+	return rows, nil
+}
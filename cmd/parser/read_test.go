@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+
+	"gosmol/internal/domain"
+)
+
+func TestMapRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		records [][]string
+		want    []domain.ImportRow
+		wantErr string
+	}{
+		{
+			name: "canonical header",
+			records: [][]string{
+				{"title", "description", "student_email", "supervisor"},
+				{"Topic", "About", "ivan@example.com", "anna@example.com"},
+			},
+			want: []domain.ImportRow{{Line: 2, Title: "Topic", Description: "About", StudentEmail: "ivan@example.com", SupervisorEmail: "anna@example.com"}},
+		},
+		{
+			name: "aliases, case and spacing",
+			records: [][]string{
+				{" Topic ", "Student", "Supervisor Email"},
+				{"  Topic  ", " ivan@example.com ", ""},
+			},
+			want: []domain.ImportRow{{Line: 2, Title: "Topic", StudentEmail: "ivan@example.com"}},
+		},
+		{
+			name: "short and blank records",
+			records: [][]string{
+				{"title", "email", "description"},
+				{"Topic", "ivan@example.com"},
+				{"", " ", ""},
+				{},
+				{"Second", "petr@example.com", "Text"},
+			},
+			want: []domain.ImportRow{
+				{Line: 2, Title: "Topic", StudentEmail: "ivan@example.com"},
+				{Line: 5, Title: "Second", StudentEmail: "petr@example.com", Description: "Text"},
+			},
+		},
+		{
+			name:    "header only",
+			records: [][]string{{"title", "email"}},
+		},
+		{name: "empty file", records: nil, wantErr: "file is empty"},
+		{name: "unknown column", records: [][]string{{"title", "email", "grade"}}, wantErr: `unknown column "grade"`},
+		{name: "duplicate column", records: [][]string{{"title", "topic", "email"}}, wantErr: `column "title" appears twice`},
+		{name: "missing title", records: [][]string{{"description", "email"}}, wantErr: `missing column "title"`},
+		{name: "missing student", records: [][]string{{"title", "supervisor"}}, wantErr: `missing column "student_email"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make([]int, len(tt.records))
+			for i := range lines {
+				lines[i] = i + 1
+			}
+
+			got, err := mapRows(tt.records, lines)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mapRows error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topics.csv")
+	content := "\xef\xbb\xbftitle,description,email\n" +
+		"\"Topic, with comma\",\"Two\nlines\",ivan@example.com\n" +
+		"\n" +
+		"Second,,petr@example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readRows(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lines point at where a record starts in the file, multi-line cells
+	// included.
+	want := []domain.ImportRow{
+		{Line: 2, Title: "Topic, with comma", Description: "Two\nlines", StudentEmail: "ivan@example.com"},
+		{Line: 5, Title: "Second", StudentEmail: "petr@example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadCSVMalformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topics.csv")
+	if err := os.WriteFile(path, []byte("title,email\n\"unterminated,ivan@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readRows(path, ""); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestReadXLSX(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topics.xlsx")
+
+	file := excelize.NewFile()
+	file.SetSheetName("Sheet1", "Topics")
+	rows := [][]interface{}{
+		{"Title", "Student Email", "Supervisor"},
+		{"Topic", "ivan@example.com", "anna@example.com"},
+		{nil, nil, nil},
+		{"Second", "petr@example.com"},
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := file.SetSheetRow("Topics", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := file.NewSheet("Other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := readRows(path, "Topics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []domain.ImportRow{
+		{Line: 2, Title: "Topic", StudentEmail: "ivan@example.com", SupervisorEmail: "anna@example.com"},
+		{Line: 4, Title: "Second", StudentEmail: "petr@example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %+v, want %+v", got, want)
+	}
+
+	if _, err := readRows(path, "Missing"); err == nil {
+		t.Error("expected an error for a missing sheet")
+	}
+	if _, err := readRows(path, "Other"); err == nil {
+		t.Error("expected an error for an empty sheet")
+	}
+}
+
+func TestReadRowsUnsupportedType(t *testing.T) {
+	if _, err := readRows("topics.json", ""); err == nil || !strings.Contains(err.Error(), "unsupported file type") {
+		t.Errorf("readRows error = %v", err)
+	}
+}
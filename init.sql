@@ -8,6 +8,24 @@ CREATE TABLE IF NOT EXISTS users (
 );
 
 ALTER TABLE users ADD COLUMN IF NOT EXISTS two_fa_enabled BOOLEAN DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS two_fa_method VARCHAR(16) NOT NULL DEFAULT 'email';
+-- Accounts that existed before email verification count as verified, so
+-- turning on require_email_verification does not lock them out. Only new
+-- rows default to unverified.
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'email_verified') THEN
+        ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT true;
+        ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false;
+    END IF;
+END $$;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'student';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS supervisor_capacity INTEGER NOT NULL DEFAULT 5;
+
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_check') THEN
+        ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('student', 'supervisor', 'admin'));
+    END IF;
+END $$;
 
 CREATE TABLE IF NOT EXISTS two_fa_codes (
     id SERIAL PRIMARY KEY,
@@ -21,16 +39,237 @@ CREATE TABLE IF NOT EXISTS two_fa_codes (
     CONSTRAINT valid_code CHECK (code ~ '^[0-9]{6}$')
 );
 
+CREATE TABLE IF NOT EXISTS two_fa_totp (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    confirmed BOOLEAN DEFAULT false,
+    last_used_step BIGINT DEFAULT 0,
+    attempts INTEGER DEFAULT 0,
+    last_attempt_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS two_fa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE IF NOT EXISTS diplomas (
     id SERIAL PRIMARY KEY,
     title VARCHAR(255) NOT NULL,
     description TEXT
 );
 
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'draft';
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS supervisor_id INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS final_grade SMALLINT CHECK (final_grade BETWEEN 2 AND 5);
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS grading_finalized_at TIMESTAMP;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS deleted_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE diplomas ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('russian', COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector('english', COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector('russian', COALESCE(description, '')), 'B') ||
+    setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+) STORED;
+
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'diplomas_status_check') THEN
+        ALTER TABLE diplomas ADD CONSTRAINT diplomas_status_check
+            CHECK (status IN ('draft', 'submitted', 'under_review', 'approved', 'defended'));
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS supervision_requests (
+    id SERIAL PRIMARY KEY,
+    diploma_id INTEGER NOT NULL REFERENCES diplomas(id) ON DELETE CASCADE,
+    student_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    supervisor_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined')),
+    message TEXT,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    decided_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS diploma_reviews (
+    id SERIAL PRIMARY KEY,
+    diploma_id INTEGER NOT NULL REFERENCES diplomas(id) ON DELETE CASCADE,
+    reviewer_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    grade SMALLINT NOT NULL CHECK (grade BETWEEN 2 AND 5),
+    comment TEXT,
+    weighted_score NUMERIC(4, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (diploma_id, reviewer_id)
+);
+
+CREATE TABLE IF NOT EXISTS diploma_review_scores (
+    review_id INTEGER NOT NULL REFERENCES diploma_reviews(id) ON DELETE CASCADE,
+    criterion VARCHAR(32) NOT NULL,
+    score SMALLINT NOT NULL CHECK (score BETWEEN 2 AND 5),
+    comment TEXT,
+    PRIMARY KEY (review_id, criterion)
+);
+
+CREATE TABLE IF NOT EXISTS diploma_files (
+    id SERIAL PRIMARY KEY,
+    diploma_id INTEGER NOT NULL REFERENCES diplomas(id) ON DELETE CASCADE,
+    storage_key VARCHAR(255) UNIQUE NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS diploma_status_history (
+    id SERIAL PRIMARY KEY,
+    diploma_id INTEGER NOT NULL REFERENCES diplomas(id) ON DELETE CASCADE,
+    from_status VARCHAR(16) NOT NULL,
+    to_status VARCHAR(16) NOT NULL,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every create, edit, delete, restore and revert of a diploma leaves a full
+-- snapshot here, numbered by the diploma version it produced.
+CREATE TABLE IF NOT EXISTS diploma_revisions (
+    id SERIAL PRIMARY KEY,
+    diploma_id INTEGER NOT NULL REFERENCES diplomas(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    action VARCHAR(16) NOT NULL CHECK (action IN ('create', 'update', 'delete', 'restore', 'revert', 'transition', 'supervise', 'grade')),
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    status VARCHAR(16) NOT NULL,
+    author_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (diploma_id, version)
+);
+
+-- Revisions are append-only. Rows only change through foreign key cascades,
+-- which run one trigger level deeper: they go with a purged diploma and lose
+-- the author when the user is deleted.
+CREATE OR REPLACE FUNCTION diploma_revisions_append_only() RETURNS trigger AS $$
+BEGIN
+    IF pg_trigger_depth() > 1 THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'diploma revisions are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'diploma_revisions_action_check'
+                   AND pg_get_constraintdef(oid) LIKE '%transition%') THEN
+        ALTER TABLE diploma_revisions DROP CONSTRAINT IF EXISTS diploma_revisions_action_check;
+        ALTER TABLE diploma_revisions ADD CONSTRAINT diploma_revisions_action_check
+            CHECK (action IN ('create', 'update', 'delete', 'restore', 'revert', 'transition', 'supervise', 'grade'));
+    END IF;
+END $$;
+
+DROP TRIGGER IF EXISTS diploma_revisions_append_only ON diploma_revisions;
+CREATE TRIGGER diploma_revisions_append_only
+    BEFORE UPDATE OR DELETE ON diploma_revisions
+    FOR EACH ROW EXECUTE FUNCTION diploma_revisions_append_only();
+
+-- Raw refresh tokens were stored before token families existed. They cannot
+-- be hashed retroactively, so the old table is dropped and users log in again.
+DO $$ BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'refresh_token' AND column_name = 'token') THEN
+        DROP TABLE refresh_token;
+    END IF;
+END $$;
+
+-- One session per login. Its id doubles as the refresh token family id and is
+-- carried in the "sid" claim of access tokens.
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT,
+    ip VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
 CREATE TABLE IF NOT EXISTS refresh_token (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    family_id VARCHAR(64) NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES refresh_token(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Token families created before sessions existed have no session row.
+DO $$ BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'refresh_token_family_id_fkey') THEN
+        DELETE FROM refresh_token WHERE family_id NOT IN (SELECT id FROM sessions);
+        ALTER TABLE refresh_token ADD CONSTRAINT refresh_token_family_id_fkey
+            FOREIGN KEY (family_id) REFERENCES sessions(id) ON DELETE CASCADE;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS security_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    event VARCHAR(64) NOT NULL,
+    details TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body_text TEXT,
+    body_html TEXT,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+
+    CONSTRAINT valid_outbox_status CHECK (status IN ('pending', 'sent', 'dead'))
+);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
-    token TEXT NOT NULL,
-    expires_at TIMESTAMP NOT NULL
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE IF NOT EXISTS login_attempts (
@@ -42,14 +281,23 @@ CREATE TABLE IF NOT EXISTS login_attempts (
 
 DELETE FROM two_fa_codes WHERE expires_at < NOW() - INTERVAL '1 hour';
 DELETE FROM login_attempts WHERE attempt_time < NOW() - INTERVAL '24 hours';
-DELETE FROM refresh_token WHERE expires_at < NOW();
+DELETE FROM sessions WHERE id IN (
+    SELECT family_id FROM refresh_token GROUP BY family_id HAVING MAX(expires_at) < NOW()
+);
+DELETE FROM password_reset_tokens WHERE expires_at < NOW() - INTERVAL '1 day';
+DELETE FROM email_verification_tokens WHERE expires_at < NOW() - INTERVAL '7 days';
+UPDATE outbox SET body_text = NULL, body_html = NULL WHERE status IN ('sent', 'dead') AND (body_text IS NOT NULL OR body_html IS NOT NULL);
+DELETE FROM outbox WHERE status = 'sent' AND sent_at < NOW() - INTERVAL '7 days';
+DELETE FROM outbox WHERE status = 'dead' AND next_attempt_at < NOW() - INTERVAL '30 days';
 
-INSERT INTO users (firstname, lastname, email, password_hash, two_fa_enabled) VALUES
-('Иван', 'Иванов', 'ivan@example.com', '$2a$10$WoBnb8Ao2ah5somIbd4a5ukKglisIpp1QQ/g7oByqbQBFwGSECS36', true),
-('Петр', 'Петров', 'petr@example.com', '$2a$10$WoBnb8Ao2ah5somIbd4a5ukKglisIpp1QQ/g7oByqbQBFwGSECS36', false),
-('Мария', 'Сидорова', 'maria@example.com', '$2a$10$WoBnb8Ao2ah5somIbd4a5ukKglisIpp1QQ/g7oByqbQBFwGSECS36', false)
+INSERT INTO users (firstname, lastname, email, password_hash, two_fa_enabled, email_verified, role) VALUES
+('Иван', 'Иванов', 'ivan@example.com', '$2a$10$WoBnb8Ao2ah5somIbd4a5ukKglisIpp1QQ/g7oByqbQBFwGSECS36', true, true, 'admin'),
+('Петр', 'Петров', 'petr@example.com', '$2a$10$WoBnb8Ao2ah5somIbd4a5ukKglisIpp1QQ/g7oByqbQBFwGSECS36', false, true, 'student'),
+('Мария', 'Сидорова', 'maria@example.com', '$2a$10$WoBnb8Ao2ah5somIbd4a5ukKglisIpp1QQ/g7oByqbQBFwGSECS36', false, true, 'supervisor')
 ON CONFLICT (email) DO UPDATE SET
-    two_fa_enabled = EXCLUDED.two_fa_enabled;
+    two_fa_enabled = EXCLUDED.two_fa_enabled,
+    email_verified = EXCLUDED.email_verified,
+    role = EXCLUDED.role;
 
 INSERT INTO diplomas (title, description) VALUES
 ('Диплом по веб-разработке', 'Исследование современных фреймворков для веб-разработки'),
@@ -59,6 +307,12 @@ INSERT INTO diplomas (title, description) VALUES
 ('Диплом по мобильной разработке', 'Сравнение кроссплатформенных решений')
 ON CONFLICT DO NOTHING;
 
+-- Diplomas created before revisions existed start their history here.
+INSERT INTO diploma_revisions (diploma_id, version, action, title, description, status, author_id, created_at)
+SELECT d.id, d.version, 'create', d.title, d.description, d.status, d.owner_id, COALESCE(d.updated_at, CURRENT_TIMESTAMP)
+FROM diplomas d
+WHERE NOT EXISTS (SELECT 1 FROM diploma_revisions r WHERE r.diploma_id = d.id);
+
 CREATE INDEX IF NOT EXISTS idx_two_fa_codes_user_id ON two_fa_codes(user_id);
 CREATE INDEX IF NOT EXISTS idx_two_fa_codes_expires ON two_fa_codes(expires_at);
 CREATE INDEX IF NOT EXISTS idx_two_fa_codes_code ON two_fa_codes(code);
@@ -66,6 +320,24 @@ CREATE INDEX IF NOT EXISTS idx_two_fa_codes_used ON two_fa_codes(is_used) WHERE
 
 CREATE INDEX IF NOT EXISTS idx_two_fa_codes_user_created ON two_fa_codes(user_id, created_at DESC);
 CREATE INDEX IF NOT EXISTS idx_two_fa_codes_created_at ON two_fa_codes(created_at);
+CREATE INDEX IF NOT EXISTS idx_two_fa_recovery_codes_user_hash ON two_fa_recovery_codes(user_id, code_hash);
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_created ON password_reset_tokens(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_created ON email_verification_tokens(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_family ON refresh_token(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_token_user ON refresh_token(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_security_events_user_created ON security_events(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_status ON outbox(status, id DESC);
+CREATE INDEX IF NOT EXISTS idx_diplomas_owner ON diplomas(owner_id);
+CREATE INDEX IF NOT EXISTS idx_diplomas_status ON diplomas(status);
+CREATE INDEX IF NOT EXISTS idx_diplomas_supervisor ON diplomas(supervisor_id);
+CREATE INDEX IF NOT EXISTS idx_diplomas_search ON diplomas USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_diplomas_deleted ON diplomas(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_diploma_files_diploma ON diploma_files(diploma_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_supervision_requests_pending ON supervision_requests(diploma_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_supervision_requests_supervisor ON supervision_requests(supervisor_id, status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_diploma_status_history_diploma ON diploma_status_history(diploma_id, created_at);
 CREATE INDEX IF NOT EXISTS idx_users_two_fa_enabled ON users(two_fa_enabled) WHERE two_fa_enabled = true;
 
 SELECT setval('users_id_seq', (SELECT COALESCE(MAX(id), 1) FROM users));
@@ -77,4 +349,5 @@ DO $$ BEGIN
     RAISE NOTICE 'Two-factor authentication support added';
     RAISE NOTICE 'Users with 2FA enabled: Ivan, Maria';
     RAISE NOTICE 'User with 2FA disabled: Petr';
+    RAISE NOTICE 'Roles: Ivan admin, Maria supervisor, Petr student';
 END $$;
\ No newline at end of file
@@ -0,0 +1,194 @@
+package rest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"gosmol/internal/domain"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// diplomaExporter writes diplomas in one export format. header is called
+// once before the first row, close after the last one.
+type diplomaExporter interface {
+	header() error
+	row(diploma domain.Diploma) error
+	close() error
+}
+
+type exportFormat struct {
+	contentType string
+	open        func(w io.Writer) diplomaExporter
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv": {
+		contentType: "text/csv; charset=utf-8",
+		open:        func(w io.Writer) diplomaExporter { return &csvExporter{w: w, csv: csv.NewWriter(w)} },
+	},
+	"xlsx": {
+		contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		open:        func(w io.Writer) diplomaExporter { return &xlsxExporter{w: w} },
+	},
+	"jsonl": {
+		contentType: "application/x-ndjson",
+		open:        func(w io.Writer) diplomaExporter { return &jsonlExporter{encoder: json.NewEncoder(w)} },
+	},
+}
+
+var exportColumns = []string{
+	"id", "title", "description", "status", "student_name", "student_email",
+	"supervisor_id", "final_grade", "created_at", "updated_at",
+}
+
+// exportValues lays a diploma out along exportColumns. Missing values are
+// nil so every format can leave the cell empty.
+func exportValues(diploma domain.Diploma) []interface{} {
+	values := []interface{}{
+		diploma.ID, diploma.Title, diploma.Description, diploma.Status, nil, nil,
+		nil, nil, diploma.CreatedAt, diploma.UpdatedAt,
+	}
+	if diploma.Student != nil {
+		values[4] = diploma.Student.Firstname + " " + diploma.Student.Lastname
+		values[5] = diploma.Student.Email
+	}
+	if diploma.SupervisorID != nil {
+		values[6] = *diploma.SupervisorID
+	}
+	if diploma.FinalGrade != nil {
+		values[7] = *diploma.FinalGrade
+	}
+	return values
+}
+
+type csvExporter struct {
+	w   io.Writer
+	csv *csv.Writer
+}
+
+// header starts with a byte order mark so spreadsheet programs read the
+// file as UTF-8.
+func (e *csvExporter) header() error {
+	if _, err := io.WriteString(e.w, "\ufeff"); err != nil {
+		return err
+	}
+	return e.csv.Write(exportColumns)
+}
+
+func (e *csvExporter) row(diploma domain.Diploma) error {
+	values := exportValues(diploma)
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+		case time.Time:
+			record[i] = v.Format(time.RFC3339)
+		case string:
+			record[i] = escapeFormula(v)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return e.csv.Write(record)
+}
+
+// escapeFormula prefixes text that a spreadsheet would evaluate as a formula
+// with a quote, so user-supplied titles cannot inject formulas into the CSV.
+// XLSX cells are typed as strings and need no escaping.
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e *csvExporter) close() error {
+	e.csv.Flush()
+	return e.csv.Error()
+}
+
+// xlsxExporter writes rows through excelize's stream writer, which keeps
+// them in a temporary file; the workbook is only sent once it is complete.
+type xlsxExporter struct {
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	// dates is the style that shows timestamps with a four-digit year.
+	dates int
+	next  int
+}
+
+const exportSheet = "Diplomas"
+
+func (e *xlsxExporter) header() error {
+	e.file = excelize.NewFile()
+	if err := e.file.SetSheetName("Sheet1", exportSheet); err != nil {
+		return err
+	}
+
+	stream, err := e.file.NewStreamWriter(exportSheet)
+	if err != nil {
+		return err
+	}
+	e.stream = stream
+
+	layout := "yyyy-mm-dd hh:mm:ss"
+	e.dates, err = e.file.NewStyle(&excelize.Style{CustomNumFmt: &layout})
+	if err != nil {
+		return err
+	}
+
+	header := make([]interface{}, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column
+	}
+	return e.writeRow(header)
+}
+
+func (e *xlsxExporter) row(diploma domain.Diploma) error {
+	values := exportValues(diploma)
+	for i, value := range values {
+		if _, ok := value.(time.Time); ok {
+			values[i] = excelize.Cell{StyleID: e.dates, Value: value}
+		}
+	}
+	return e.writeRow(values)
+}
+
+func (e *xlsxExporter) writeRow(values []interface{}) error {
+	e.next++
+	cell, err := excelize.CoordinatesToCellName(1, e.next)
+	if err != nil {
+		return err
+	}
+	return e.stream.SetRow(cell, values)
+}
+
+func (e *xlsxExporter) close() error {
+	defer e.file.Close()
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+	_, err := e.file.WriteTo(e.w)
+	return err
+}
+
+type jsonlExporter struct {
+	encoder *json.Encoder
+}
+
+func (e *jsonlExporter) header() error {
+	return nil
+}
+
+func (e *jsonlExporter) row(diploma domain.Diploma) error {
+	return e.encoder.Encode(diploma)
+}
+
+func (e *jsonlExporter) close() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"gosmol/internal/domain"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeFormula(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"=SUM(A1:A2)", "'=SUM(A1:A2)"},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"", ""},
+		{"Plain title", "Plain title"},
+		{"a=b", "a=b"},
+		{" =1", " =1"},
+		{"'=1", "'=1"},
+		{"Тема диплома", "Тема диплома"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeFormula(tt.value); got != tt.want {
+			t.Errorf("escapeFormula(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCSVExporterEscapesText(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := exportFormats["csv"].open(&buf)
+
+	supervisorID := int64(-1)
+	grade := 5
+	created := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	diploma := domain.Diploma{
+		ID:           7,
+		Title:        `=HYPERLINK("http://evil.example","click")`,
+		Description:  "-1",
+		Status:       domain.DiplomaStatusDraft,
+		Student:      &domain.StudentProfile{Firstname: "@Ivan", Lastname: "Ivanov", Email: "+ivan@example.com"},
+		SupervisorID: &supervisorID,
+		FinalGrade:   &grade,
+		CreatedAt:    created,
+		UpdatedAt:    created,
+	}
+
+	if err := exporter.header(); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.row(diploma); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), "\xef\xbb\xbf") {
+		t.Error("csv export does not start with a byte order mark")
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\xef\xbb\xbf"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+
+	want := []string{
+		"7", `'=HYPERLINK("http://evil.example","click")`, "'-1", "draft", "'@Ivan Ivanov", "'+ivan@example.com",
+		"-1", "5", "2026-06-01T12:00:00Z", "2026-06-01T12:00:00Z",
+	}
+	for i, column := range exportColumns {
+		if records[1][i] != want[i] {
+			t.Errorf("%s = %q, want %q", column, records[1][i], want[i])
+		}
+	}
+}
@@ -2,70 +2,436 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"gosmol/internal/apperror"
 	"gosmol/internal/domain"
 	"gosmol/pkg/logging"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
-	"fmt"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 type DiplomasService interface {
-	GetResources(limits int64) ([]domain.Diploma, error)
+	GetResources(query domain.DiplomaQuery) (domain.DiplomaPage, error)
+	ExportResources(query domain.DiplomaQuery, emit func(domain.Diploma) error) error
 	GetResource(id int64) (domain.Diploma, error)
+	GetStudentResources(studentID int64) ([]domain.Diploma, error)
 	CreateResource(diploma domain.Diploma) (domain.Diploma, error)
-    UpdateResource(id int64, diploma domain.Diploma) (domain.Diploma, error)
-	DeleteResource(id int64) error
+	UpdateResource(id int64, diploma domain.Diploma, ifMatch []int64, actor domain.Actor) (domain.Diploma, error)
+	PatchResource(id int64, format string, patch []byte, ifMatch []int64, actor domain.Actor) (domain.Diploma, error)
+	DeleteResource(id int64, ifMatch []int64, actor domain.Actor) error
+	RestoreResource(id int64, actor domain.Actor) (domain.Diploma, error)
+	GetRevisions(id int64) ([]domain.DiplomaRevision, error)
+	GetRevision(id int64, version int64) (domain.DiplomaRevision, error)
+	DiffRevisions(id int64, from int64, to int64) (domain.DiplomaDiff, error)
+	RevertResource(id int64, version int64, ifMatch []int64, actor domain.Actor) (domain.Diploma, error)
+	Transition(id int64, action string, actor domain.Actor, reason string) (domain.Diploma, error)
+	GetStatusHistory(id int64) ([]domain.DiplomaStatusChange, error)
+	GetSupervisors() ([]domain.Supervisor, error)
+	SetSupervisorCapacity(supervisorID int64, capacity int) error
+	RequestSupervision(diplomaID int64, input domain.SupervisionRequestInput, actor domain.Actor) (domain.SupervisionRequest, error)
+	GetSupervisionRequests(supervisorID int64, status string) ([]domain.SupervisionRequest, error)
+	GetSupervisedResources(supervisorID int64) ([]domain.Diploma, error)
+	DecideSupervisionRequest(id int64, accept bool, reason string, actor domain.Actor) (domain.SupervisionRequest, error)
+	SubmitReview(diplomaID int64, input domain.ReviewInput, actor domain.Actor) (domain.Review, error)
+	GetReviews(diplomaID int64) (domain.ReviewSummary, error)
+	FinalizeGrading(diplomaID int64, actor domain.Actor) (domain.ReviewSummary, error)
 }
 
 type DiplomasHandler struct {
 	service DiplomasService
-	logger *logging.Logger
+	logger  *logging.Logger
 }
 
 func NewDiplomasHandler(s DiplomasService, l *logging.Logger) *DiplomasHandler {
 	return &DiplomasHandler{
 		service: s,
-		logger: l,
+		logger:  l,
 	}
 }
 
 const (
-	resourceURL = "/api/resource/:id"
-	resourcesURL = "/api/resources"
+	resourceURL            = "/api/resource/:id"
+	resourcesURL           = "/api/resources"
+	resourcesExportURL     = "/api/resources/export"
+	myResourcesURL         = "/api/students/me/diplomas"
+	resourceHistoryURL     = "/api/resource/:id/history"
+	resourceRestoreURL     = "/api/resource/:id/restore"
+	trashURL               = "/api/admin/resources/trash"
+	resourceRevisionsURL   = "/api/resource/:id/revisions"
+	resourceRevisionURL    = "/api/resource/:id/revisions/:version"
+	resourceRevertURL      = "/api/resource/:id/revisions/:version/revert"
+	resourceDiffURL        = "/api/resource/:id/diff"
+	resourceSupervisionURL = "/api/resource/:id/supervision-requests"
+	resourceReviewsURL     = "/api/resource/:id/reviews"
+	resourceFinalizeURL    = "/api/resource/:id/reviews/finalize"
+	supervisorsURL         = "/api/supervisors"
+	supervisorCapacityURL  = "/api/admin/supervisors/:id/capacity"
+	myRequestsURL          = "/api/supervisors/me/requests"
+	mySupervisedURL        = "/api/supervisors/me/diplomas"
+	requestAcceptURL       = "/api/supervision-requests/:id/accept"
+	requestDeclineURL      = "/api/supervision-requests/:id/decline"
 )
 
 var dip []domain.Diploma
 
-func (d *DiplomasHandler) Register(router *httprouter.Router, jwtSecret string) {
-	router.Handler(http.MethodGet, resourcesURL, apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(d.get))))
-	router.Handler(http.MethodGet, resourceURL, apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(d.getById))))
-	router.Handler(http.MethodPost, resourcesURL, apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(d.post))))
-	router.Handler(http.MethodPut, resourceURL, apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(d.put))))
-	router.Handler(http.MethodDelete, resourceURL, apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(d.delete))))
+func (d *DiplomasHandler) Register(router *httprouter.Router, verifier *apperror.Verifier) {
+	router.Handler(http.MethodGet, resourcesURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.list(false))))))
+	router.Handler(http.MethodGet, resourcesExportURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaExport, http.HandlerFunc(apperror.Middleware(d.export)))))
+	router.Handler(http.MethodGet, resourceURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.getById)))))
+	router.Handler(http.MethodPost, resourcesURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaCreate, http.HandlerFunc(apperror.Middleware(d.post)))))
+	router.Handler(http.MethodPut, resourceURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaUpdate, http.HandlerFunc(apperror.Middleware(d.put)))))
+	router.Handler(http.MethodPatch, resourceURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaUpdate, http.HandlerFunc(apperror.Middleware(d.patch)))))
+	router.Handler(http.MethodPost, resourceURL+"/submit", verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaUpdate, http.HandlerFunc(apperror.Middleware(d.transition(domain.DiplomaActionSubmit))))))
+	router.Handler(http.MethodPost, resourceURL+"/review", verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaApprove, http.HandlerFunc(apperror.Middleware(d.transition(domain.DiplomaActionReview))))))
+	router.Handler(http.MethodPost, resourceURL+"/approve", verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaApprove, http.HandlerFunc(apperror.Middleware(d.transition(domain.DiplomaActionApprove))))))
+	router.Handler(http.MethodPost, resourceURL+"/reject", verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaApprove, http.HandlerFunc(apperror.Middleware(d.transition(domain.DiplomaActionReject))))))
+	router.Handler(http.MethodPost, resourceURL+"/defend", verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaApprove, http.HandlerFunc(apperror.Middleware(d.transition(domain.DiplomaActionDefend))))))
+	router.Handler(http.MethodGet, resourceHistoryURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.history)))))
+	router.Handler(http.MethodPost, resourceSupervisionURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaUpdate, http.HandlerFunc(apperror.Middleware(d.requestSupervision)))))
+	router.Handler(http.MethodGet, resourceReviewsURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.getReviews)))))
+	router.Handler(http.MethodPost, resourceReviewsURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaReview, http.HandlerFunc(apperror.Middleware(d.postReview)))))
+	router.Handler(http.MethodPost, resourceFinalizeURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermGradeFinalize, http.HandlerFunc(apperror.Middleware(d.finalizeGrading)))))
+	router.Handler(http.MethodGet, supervisorsURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.getSupervisors)))))
+	router.Handler(http.MethodPut, supervisorCapacityURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermUsersManage, http.HandlerFunc(apperror.Middleware(d.setSupervisorCapacity)))))
+	router.Handler(http.MethodGet, myRequestsURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermSupervise, http.HandlerFunc(apperror.Middleware(d.getSupervisionRequests)))))
+	router.Handler(http.MethodGet, mySupervisedURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermSupervise, http.HandlerFunc(apperror.Middleware(d.getSupervised)))))
+	router.Handler(http.MethodPost, requestAcceptURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermSupervise, http.HandlerFunc(apperror.Middleware(d.decideSupervision(true))))))
+	router.Handler(http.MethodPost, requestDeclineURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermSupervise, http.HandlerFunc(apperror.Middleware(d.decideSupervision(false))))))
+	router.Handler(http.MethodGet, myResourcesURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.getMine)))))
+	router.Handler(http.MethodDelete, resourceURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaDelete, http.HandlerFunc(apperror.Middleware(d.delete)))))
+	router.Handler(http.MethodGet, trashURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaDelete, http.HandlerFunc(apperror.Middleware(d.list(true))))))
+	router.Handler(http.MethodGet, resourceRevisionsURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.revisions)))))
+	router.Handler(http.MethodGet, resourceRevisionURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.revision)))))
+	router.Handler(http.MethodPost, resourceRevertURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaUpdate, http.HandlerFunc(apperror.Middleware(d.revert)))))
+	router.Handler(http.MethodGet, resourceDiffURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(d.diff)))))
+	router.Handler(http.MethodPost, resourceRestoreURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaDelete, http.HandlerFunc(apperror.Middleware(d.restore)))))
 }
 
-func (d *DiplomasHandler) get(w http.ResponseWriter, r *http.Request) error {
-	w.Header().Set("Content-Type", "application/json")
-	var limits int64 = 25
+// list serves the diploma listing, or the trash when deleted is set. Both
+// take the same query parameters.
+func (d *DiplomasHandler) list(deleted bool) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+		query, err := parseDiplomaQuery(r)
+		if err != nil {
+			d.logger.Error("Failed to parse query: " + err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+		query.Deleted = deleted
+
+		page, err := d.service.GetResources(query)
+		if err != nil {
+			d.logger.Error("Failed to search resources: " + err.Error())
+			http.Error(w, err.Error(), diplomaReadErrorStatus(err))
+			return err
+		}
+
+		switch {
+		case query.Cursor != "":
+			if page.NextCursor != nil {
+				page.Next = cursorLink(r, *page.NextCursor)
+			}
+		default:
+			if page.Page > 1 {
+				page.Prev = pageLink(r, page.Page-1)
+			}
+			if page.NextCursor != nil {
+				page.Next = pageLink(r, page.Page+1)
+			}
+		}
+
+		json.NewEncoder(w).Encode(page)
+		return nil
+	}
+}
+
+// export streams the diplomas matching the listing filters as a file. The
+// download headers are only sent with the first row, so a bad query still
+// gets a plain error response.
+func (d *DiplomasHandler) export(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		err := fmt.Errorf("%w: format must be csv, xlsx or jsonl", domain.ErrInvalidQuery)
+		d.logger.Error("Failed to parse query: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
 
-	diplomas, err := d.service.GetResources(limits)
+	query, err := parseDiplomaQuery(r)
 	if err != nil {
-		d.logger.Error("Failed to search resources: " + err.Error())
+		d.logger.Error("Failed to parse query: " + err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return err
 	}
 
-	json.NewEncoder(w).Encode(diplomas)
+	exporter := format.open(w)
+	started := false
+	start := func() error {
+		if started {
+			return nil
+		}
+		started = true
+		filename := fmt.Sprintf("diplomas-%s.%s", time.Now().Format("20060102-150405"), name)
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		return exporter.header()
+	}
+
+	err = d.service.ExportResources(query, func(diploma domain.Diploma) error {
+		if err := start(); err != nil {
+			return err
+		}
+		return exporter.row(diploma)
+	})
+	if err != nil && !started {
+		d.logger.Error("Failed to export resources: " + err.Error())
+		http.Error(w, err.Error(), diplomaReadErrorStatus(err))
+		return err
+	}
+	if err == nil {
+		err = start()
+	}
+	if err == nil {
+		err = exporter.close()
+	}
+
+	// Once the first row is out the status is sent, so a failure can only
+	// cut the download short.
+	if err != nil {
+		d.logger.Error("Export interrupted: " + err.Error())
+	}
 	return nil
 }
 
+func (d *DiplomasHandler) restore(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	diploma, err := d.service.RestoreResource(id, actorFromContext(r))
+	if err != nil {
+		d.logger.Error("Failed to restore resource: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	w.Header().Set("ETag", diplomaETag(diploma.Version))
+	return json.NewEncoder(w).Encode(diploma)
+}
+
+func (d *DiplomasHandler) revisions(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	revisions, err := d.service.GetRevisions(id)
+	if err != nil {
+		d.logger.Error("Failed to get resource revisions: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(revisions)
+}
+
+func (d *DiplomasHandler) revision(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 64)
+	if err != nil || version < 1 {
+		http.Error(w, domain.ErrRevisionNotFound.Error(), http.StatusNotFound)
+		return domain.ErrRevisionNotFound
+	}
+
+	revision, err := d.service.GetRevision(id, version)
+	if err != nil {
+		d.logger.Error("Failed to get resource revision: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(revision)
+}
+
+func (d *DiplomasHandler) revert(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 64)
+	if err != nil || version < 1 {
+		http.Error(w, domain.ErrRevisionNotFound.Error(), http.StatusNotFound)
+		return domain.ErrRevisionNotFound
+	}
+
+	ifMatch, ok := parseIfMatch(r)
+	if !ok {
+		http.Error(w, domain.ErrVersionMismatch.Error(), http.StatusPreconditionFailed)
+		return domain.ErrVersionMismatch
+	}
+
+	diploma, err := d.service.RevertResource(id, version, ifMatch, actorFromContext(r))
+	if err != nil {
+		d.logger.Error("Failed to revert resource: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	w.Header().Set("ETag", diplomaETag(diploma.Version))
+	return json.NewEncoder(w).Encode(diploma)
+}
+
+// diff compares the revisions given by the from and to query parameters,
+// to defaulting to the latest one.
+func (d *DiplomasHandler) diff(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	from, err := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("%w: from must be a revision number", domain.ErrInvalidQuery)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	var to int64
+	if value := r.URL.Query().Get("to"); value != "" {
+		to, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("%w: to must be a revision number", domain.ErrInvalidQuery)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+
+	diff, err := d.service.DiffRevisions(id, from, to)
+	if err != nil {
+		d.logger.Error("Failed to diff resource revisions: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(diff)
+}
+
+// parseDiplomaQuery reads the listing parameters. Missing ones stay zero and
+// get their defaults in the service.
+func parseDiplomaQuery(r *http.Request) (domain.DiplomaQuery, error) {
+	values := r.URL.Query()
+	query := domain.DiplomaQuery{
+		Q:      values.Get("q"),
+		Status: values.Get("status"),
+		Sort:   values.Get("sort"),
+		Order:  values.Get("order"),
+		Cursor: values.Get("cursor"),
+	}
+
+	ints := map[string]*int64{
+		"page":          &query.Page,
+		"limit":         &query.Limit,
+		"owner_id":      &query.OwnerID,
+		"supervisor_id": &query.SupervisorID,
+	}
+	for name, dest := range ints {
+		if values.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(values.Get(name), 10, 64)
+		if err != nil || n < 1 {
+			return domain.DiplomaQuery{}, fmt.Errorf("%w: %s must be a positive integer", domain.ErrInvalidQuery, name)
+		}
+		*dest = n
+	}
+
+	times := map[string]**time.Time{
+		"created_from": &query.CreatedFrom,
+		"created_to":   &query.CreatedTo,
+	}
+	for name, dest := range times {
+		if values.Get(name) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, values.Get(name))
+		if err != nil {
+			// A plain date covers the whole day, so created_to=2024-06-30
+			// still includes diplomas created on June 30.
+			t, err = time.Parse("2006-01-02", values.Get(name))
+			if err != nil {
+				return domain.DiplomaQuery{}, fmt.Errorf("%w: %s must be a date or RFC 3339 time", domain.ErrInvalidQuery, name)
+			}
+			if name == "created_to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		*dest = &t
+	}
+
+	return query, nil
+}
+
+// pageLink is the request URL with only the page parameter replaced.
+func pageLink(r *http.Request, page int64) *string {
+	values := r.URL.Query()
+	values.Set("page", strconv.FormatInt(page, 10))
+	link := r.URL.Path + "?" + values.Encode()
+	return &link
+}
+
+// cursorLink is the request URL continuing after the given cursor.
+func cursorLink(r *http.Request, token string) *string {
+	values := r.URL.Query()
+	values.Del("page")
+	values.Set("cursor", token)
+	link := r.URL.Path + "?" + values.Encode()
+	return &link
+}
+
 func (d *DiplomasHandler) getById(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
 	params := httprouter.ParamsFromContext(r.Context())
-	idParams, err := strconv.Atoi(params.ByName("id")) 
+	idParams, err := strconv.Atoi(params.ByName("id"))
 	if err != nil {
 		d.logger.Error("Failed to params: " + err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -76,7 +442,34 @@ func (d *DiplomasHandler) getById(w http.ResponseWriter, r *http.Request) error
 	diplomas, err := d.service.GetResource(id)
 	if err != nil {
 		d.logger.Error("Failed to search resource: " + err.Error())
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	etag := diplomaETag(diplomas.Version)
+	w.Header().Set("ETag", etag)
+	if noneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	json.NewEncoder(w).Encode(diplomas)
+	return nil
+}
+
+func (d *DiplomasHandler) getMine(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	studentID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	diplomas, err := d.service.GetStudentResources(studentID)
+	if err != nil {
+		d.logger.Error("Failed to search student resources: " + err.Error())
+		http.Error(w, err.Error(), diplomaReadErrorStatus(err))
 		return err
 	}
 
@@ -85,100 +478,523 @@ func (d *DiplomasHandler) getById(w http.ResponseWriter, r *http.Request) error
 }
 
 func (d *DiplomasHandler) post(w http.ResponseWriter, r *http.Request) error {
-    w.Header().Set("Content-Type", "application/json")
-    var diploma domain.Diploma
-    if err := json.NewDecoder(r.Body).Decode(&diploma); err != nil {
-        d.logger.Error("Failed to decode JSON: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-    defer r.Body.Close()
-
-    fmt.Printf("DEBUG HANDLER DIPLOMA CREATE: Received - Title: %s\n", diploma.Title)
-
-    createdDiploma, err := d.service.CreateResource(diploma)
-    if err != nil {
-        d.logger.Error("Failed to create resource: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    fmt.Printf("DEBUG HANDLER DIPLOMA CREATE: Diploma created with ID: %d\n", createdDiploma.ID)
-    
-    w.WriteHeader(http.StatusCreated)
-    return json.NewEncoder(w).Encode(createdDiploma)
+	w.Header().Set("Content-Type", "application/json")
+	var diploma domain.Diploma
+	if err := json.NewDecoder(r.Body).Decode(&diploma); err != nil {
+		d.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	fmt.Printf("DEBUG HANDLER DIPLOMA CREATE: Received - Title: %s\n", diploma.Title)
+
+	studentID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	diploma.OwnerID = studentID
+
+	createdDiploma, err := d.service.CreateResource(diploma)
+	if err != nil {
+		d.logger.Error("Failed to create resource: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	fmt.Printf("DEBUG HANDLER DIPLOMA CREATE: Diploma created with ID: %d\n", createdDiploma.ID)
+
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(createdDiploma)
 }
 
 func (d *DiplomasHandler) put(w http.ResponseWriter, r *http.Request) error {
-    fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Method called\n")
-    
-    w.Header().Set("Content-Type", "application/json")
-
-    params := httprouter.ParamsFromContext(r.Context())
-    fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Params: %+v\n", params)
-    
-    idParams, err := strconv.Atoi(params.ByName("id"))
-    if err != nil {
-        fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Param error: %v\n", err)
-        d.logger.Error("Failed to params: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    id := int64(idParams)
-    fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Updating diploma ID: %d\n", id)
-
-    var diploma domain.Diploma
-    if err := json.NewDecoder(r.Body).Decode(&diploma); err != nil {
-        fmt.Printf("DEBUG HANDLER DIPLOMA PUT: JSON decode error: %v\n", err)
-        d.logger.Error("Failed to decode JSON: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-    defer r.Body.Close()
-
-    fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Received diploma - Title: %s\n", diploma.Title)
-
-    updatedDiploma, err := d.service.UpdateResource(id, diploma)
-    if err != nil {
-        fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Service error: %v\n", err)
-        d.logger.Error("Failed to update resource: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Successfully updated diploma ID: %d\n", updatedDiploma.ID)
-    
-    return json.NewEncoder(w).Encode(updatedDiploma)
+	fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Method called\n")
+
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Params: %+v\n", params)
+
+	idParams, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Param error: %v\n", err)
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	id := int64(idParams)
+	fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Updating diploma ID: %d\n", id)
+
+	var diploma domain.Diploma
+	if err := json.NewDecoder(r.Body).Decode(&diploma); err != nil {
+		fmt.Printf("DEBUG HANDLER DIPLOMA PUT: JSON decode error: %v\n", err)
+		d.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Received diploma - Title: %s\n", diploma.Title)
+
+	ifMatch, ok := parseIfMatch(r)
+	if !ok {
+		http.Error(w, domain.ErrVersionMismatch.Error(), http.StatusPreconditionFailed)
+		return domain.ErrVersionMismatch
+	}
+
+	updatedDiploma, err := d.service.UpdateResource(id, diploma, ifMatch, actorFromContext(r))
+	if err != nil {
+		fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Service error: %v\n", err)
+		d.logger.Error("Failed to update resource: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	fmt.Printf("DEBUG HANDLER DIPLOMA PUT: Successfully updated diploma ID: %d\n", updatedDiploma.ID)
+	w.Header().Set("ETag", diplomaETag(updatedDiploma.Version))
+
+	return json.NewEncoder(w).Encode(updatedDiploma)
+}
+
+// maxPatchSize caps the body of a PATCH request; a patch only ever touches
+// the title and description.
+const maxPatchSize = 1 << 20
+
+func (d *DiplomasHandler) patch(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	format, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (format != domain.MergePatch && format != domain.JSONPatch) {
+		w.Header().Set("Accept-Patch", domain.MergePatch+", "+domain.JSONPatch)
+		http.Error(w, domain.ErrUnsupportedPatch.Error(), http.StatusUnsupportedMediaType)
+		return domain.ErrUnsupportedPatch
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPatchSize)
+	defer r.Body.Close()
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		d.logger.Error("Failed to read patch: " + err.Error())
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return err
+	}
+
+	ifMatch, ok := parseIfMatch(r)
+	if !ok {
+		http.Error(w, domain.ErrVersionMismatch.Error(), http.StatusPreconditionFailed)
+		return domain.ErrVersionMismatch
+	}
+
+	fmt.Printf("DEBUG HANDLER DIPLOMA PATCH: Patching diploma ID: %d with %s\n", id, format)
+
+	updatedDiploma, err := d.service.PatchResource(id, format, patch, ifMatch, actorFromContext(r))
+	if err != nil {
+		d.logger.Error("Failed to patch resource: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	w.Header().Set("ETag", diplomaETag(updatedDiploma.Version))
+	return json.NewEncoder(w).Encode(updatedDiploma)
 }
 
 func (d *DiplomasHandler) delete(w http.ResponseWriter, r *http.Request) error {
-    w.Header().Set("Content-Type", "application/json")
-
-    params := httprouter.ParamsFromContext(r.Context())
-    idParams, err := strconv.Atoi(params.ByName("id")) 
-    if err != nil {
-        d.logger.Error("Failed to params: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    id := int64(idParams)
-
-    diploma, err := d.service.GetResource(id)
-    if err != nil {
-        d.logger.Error("Failed to get resource for deletion: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    if err := d.service.DeleteResource(id); err != nil {
-        d.logger.Error("Failed to delete resource: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    fmt.Printf("DEBUG HANDLER DIPLOMA DELETE: Diploma deleted with ID: %d\n", id)
-    
-    return json.NewEncoder(w).Encode(diploma)
-}
\ No newline at end of file
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	idParams, err := strconv.Atoi(params.ByName("id"))
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	id := int64(idParams)
+
+	ifMatch, ok := parseIfMatch(r)
+	if !ok {
+		http.Error(w, domain.ErrVersionMismatch.Error(), http.StatusPreconditionFailed)
+		return domain.ErrVersionMismatch
+	}
+
+	diploma, err := d.service.GetResource(id)
+	if err != nil {
+		d.logger.Error("Failed to get resource for deletion: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	if err := d.service.DeleteResource(id, ifMatch, actorFromContext(r)); err != nil {
+		d.logger.Error("Failed to delete resource: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	fmt.Printf("DEBUG HANDLER DIPLOMA DELETE: Diploma deleted with ID: %d\n", id)
+
+	return json.NewEncoder(w).Encode(diploma)
+}
+
+// transition returns the handler applying a lifecycle action. The optional
+// JSON body carries the reason, which a rejection requires.
+func (d *DiplomasHandler) transition(action string) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+
+		params := httprouter.ParamsFromContext(r.Context())
+		id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+		if err != nil {
+			d.logger.Error("Failed to params: " + err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+
+		var req domain.TransitionRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				d.logger.Error("Failed to decode JSON: " + err.Error())
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return err
+			}
+			defer r.Body.Close()
+		}
+
+		diploma, err := d.service.Transition(id, action, actorFromContext(r), req.Reason)
+		if err != nil {
+			d.logger.Error("Failed to " + action + " resource: " + err.Error())
+			http.Error(w, err.Error(), diplomaErrorStatus(err))
+			return err
+		}
+
+		return json.NewEncoder(w).Encode(diploma)
+	}
+}
+
+func (d *DiplomasHandler) history(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	history, err := d.service.GetStatusHistory(id)
+	if err != nil {
+		d.logger.Error("Failed to get resource history: " + err.Error())
+		http.Error(w, err.Error(), diplomaReadErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(history)
+}
+
+func (d *DiplomasHandler) getSupervisors(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	supervisors, err := d.service.GetSupervisors()
+	if err != nil {
+		d.logger.Error("Failed to list supervisors: " + err.Error())
+		http.Error(w, err.Error(), diplomaReadErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(supervisors)
+}
+
+func (d *DiplomasHandler) setSupervisorCapacity(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var req domain.CapacityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		d.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	if err := d.service.SetSupervisorCapacity(id, req.Capacity); err != nil {
+		d.logger.Error("Failed to set supervisor capacity: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	return json.NewEncoder(w).Encode(res)
+}
+
+func (d *DiplomasHandler) requestSupervision(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var req domain.SupervisionRequestInput
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		d.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	request, err := d.service.RequestSupervision(id, req, actorFromContext(r))
+	if err != nil {
+		d.logger.Error("Failed to request supervision: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(request)
+}
+
+func (d *DiplomasHandler) getSupervisionRequests(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	actor := actorFromContext(r)
+	requests, err := d.service.GetSupervisionRequests(actor.ID, r.URL.Query().Get("status"))
+	if err != nil {
+		d.logger.Error("Failed to list supervision requests: " + err.Error())
+		http.Error(w, err.Error(), diplomaReadErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(requests)
+}
+
+func (d *DiplomasHandler) getSupervised(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	actor := actorFromContext(r)
+	diplomas, err := d.service.GetSupervisedResources(actor.ID)
+	if err != nil {
+		d.logger.Error("Failed to list supervised resources: " + err.Error())
+		http.Error(w, err.Error(), diplomaReadErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(diplomas)
+}
+
+// decideSupervision returns the handler accepting or declining a request.
+// A decline may carry a reason in the JSON body.
+func (d *DiplomasHandler) decideSupervision(accept bool) func(w http.ResponseWriter, r *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "application/json")
+
+		params := httprouter.ParamsFromContext(r.Context())
+		id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+		if err != nil {
+			d.logger.Error("Failed to params: " + err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+
+		var req domain.TransitionRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				d.logger.Error("Failed to decode JSON: " + err.Error())
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return err
+			}
+			defer r.Body.Close()
+		}
+
+		request, err := d.service.DecideSupervisionRequest(id, accept, req.Reason, actorFromContext(r))
+		if err != nil {
+			d.logger.Error("Failed to decide supervision request: " + err.Error())
+			http.Error(w, err.Error(), diplomaErrorStatus(err))
+			return err
+		}
+
+		return json.NewEncoder(w).Encode(request)
+	}
+}
+
+func (d *DiplomasHandler) getReviews(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	summary, err := d.service.GetReviews(id)
+	if err != nil {
+		d.logger.Error("Failed to get reviews: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(summary)
+}
+
+func (d *DiplomasHandler) postReview(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var input domain.ReviewInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		d.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	review, err := d.service.SubmitReview(id, input, actorFromContext(r))
+	if err != nil {
+		d.logger.Error("Failed to submit review: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(review)
+}
+
+func (d *DiplomasHandler) finalizeGrading(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		d.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	summary, err := d.service.FinalizeGrading(id, actorFromContext(r))
+	if err != nil {
+		d.logger.Error("Failed to finalize grading: " + err.Error())
+		http.Error(w, err.Error(), diplomaErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(summary)
+}
+
+// diplomaErrorStatus maps service errors to HTTP status codes.
+func diplomaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrInvalidQuery), errors.Is(err, domain.ErrSearchTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, domain.ErrSupervisorNotFound), errors.Is(err, domain.ErrRequestNotFound),
+		errors.Is(err, domain.ErrDiplomaNotFound), errors.Is(err, domain.ErrRevisionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, domain.ErrUnsupportedPatch):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, domain.ErrInvalidPatch):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, domain.ErrInvalidTransition), errors.Is(err, domain.ErrDiplomaLocked),
+		errors.Is(err, domain.ErrCapacityReached), errors.Is(err, domain.ErrAlreadySupervised),
+		errors.Is(err, domain.ErrRequestPending), errors.Is(err, domain.ErrRequestDecided),
+		errors.Is(err, domain.ErrGradingFinalized), errors.Is(err, domain.ErrNoReviews), errors.Is(err, domain.ErrNotReviewable):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// diplomaReadErrorStatus is diplomaErrorStatus for handlers that only read.
+// Their input is an id or query filters, so any error that is not a known
+// client error comes from the server.
+func diplomaReadErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrInvalidQuery) || errors.Is(err, domain.ErrSearchTooLong) {
+		return http.StatusBadRequest
+	}
+	if status := diplomaErrorStatus(err); status != http.StatusBadRequest {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// diplomaETag is the strong entity tag of a diploma version.
+func diplomaETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseIfMatch returns the diploma versions listed in If-Match. No header or
+// "*" sets no precondition. ok is false when the header names no tag this API
+// could have issued, such as a weak tag, so it can never match.
+func parseIfMatch(r *http.Request) (versions []int64, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, len(versions) > 0
+}
+
+// noneMatch reports whether If-None-Match already names etag, using the weak
+// comparison RFC 9110 prescribes for it.
+func noneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// actorFromContext returns the user the JWT middleware authenticated.
+func actorFromContext(r *http.Request) domain.Actor {
+	studentID, _ := r.Context().Value("studentID").(int64)
+	role, _ := r.Context().Value("role").(string)
+	return domain.Actor{ID: studentID, Role: role}
+}
@@ -0,0 +1,169 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"gosmol/internal/apperror"
+	"gosmol/internal/domain"
+	"gosmol/pkg/logging"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type FilesService interface {
+	MaxSize() int64
+	Upload(diplomaID int64, filename string, r io.Reader, actor domain.Actor) (domain.DiplomaFile, error)
+	List(diplomaID int64) ([]domain.DiplomaFile, error)
+	Open(diplomaID int64, fileID int64) (domain.DiplomaFile, io.ReadSeekCloser, error)
+}
+
+type FilesHandler struct {
+	service FilesService
+	logger  *logging.Logger
+}
+
+func NewFilesHandler(s FilesService, l *logging.Logger) *FilesHandler {
+	return &FilesHandler{
+		service: s,
+		logger:  l,
+	}
+}
+
+const (
+	filesURL = "/api/resource/:id/files"
+	fileURL  = "/api/resource/:id/files/:fileId"
+
+	// multipartOverhead leaves room for part headers and boundaries on top
+	// of the file size limit.
+	multipartOverhead = 1 << 20
+)
+
+func (f *FilesHandler) Register(router *httprouter.Router, verifier *apperror.Verifier) {
+	router.Handler(http.MethodPost, filesURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaUpdate, http.HandlerFunc(apperror.Middleware(f.upload)))))
+	router.Handler(http.MethodGet, filesURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(f.list)))))
+	router.Handler(http.MethodGet, fileURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermDiplomaRead, http.HandlerFunc(apperror.Middleware(f.download)))))
+}
+
+// upload streams the "file" part of a multipart form straight to storage
+// without buffering it on disk.
+func (f *FilesHandler) upload(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		f.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, f.service.MaxSize()+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		f.logger.Error("Failed to read multipart form: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			err = errors.New("multipart form has no file part")
+		}
+		if err != nil {
+			f.logger.Error("Failed to read multipart form: " + err.Error())
+			http.Error(w, err.Error(), fileErrorStatus(err))
+			return err
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		file, err := f.service.Upload(id, part.FileName(), part, actorFromContext(r))
+		part.Close()
+		if err != nil {
+			f.logger.Error("Failed to upload file: " + err.Error())
+			http.Error(w, err.Error(), fileErrorStatus(err))
+			return err
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(file)
+	}
+}
+
+func (f *FilesHandler) list(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		f.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	files, err := f.service.List(id)
+	if err != nil {
+		f.logger.Error("Failed to list files: " + err.Error())
+		http.Error(w, err.Error(), fileErrorStatus(err))
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(files)
+}
+
+// download serves the file with http.ServeContent, which answers Range and
+// conditional requests against the content hash ETag.
+func (f *FilesHandler) download(w http.ResponseWriter, r *http.Request) error {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		f.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	fileID, err := strconv.ParseInt(params.ByName("fileId"), 10, 64)
+	if err != nil {
+		f.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	file, content, err := f.service.Open(id, fileID)
+	if err != nil {
+		f.logger.Error("Failed to open file: " + err.Error())
+		http.Error(w, err.Error(), fileErrorStatus(err))
+		return err
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+	w.Header().Set("ETag", `"`+file.SHA256+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", file.CreatedAt, content)
+	return nil
+}
+
+// fileErrorStatus maps upload and download errors to HTTP status codes.
+func fileErrorStatus(err error) int {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytes), errors.Is(err, domain.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, domain.ErrFileTypeDenied):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, domain.ErrFileNotFound):
+		return http.StatusNotFound
+	default:
+		return diplomaErrorStatus(err)
+	}
+}
@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"encoding/json"
+	"gosmol/internal/apperror"
+	"gosmol/pkg/auth"
+	"gosmol/pkg/logging"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type KeysHandler struct {
+	keys   *auth.KeySet
+	logger *logging.Logger
+}
+
+func NewKeysHandler(k *auth.KeySet, l *logging.Logger) *KeysHandler {
+	return &KeysHandler{
+		keys:   k,
+		logger: l,
+	}
+}
+
+const jwksURL = "/.well-known/jwks.json"
+
+func (k *KeysHandler) Register(router *httprouter.Router) {
+	router.HandlerFunc(http.MethodGet, jwksURL, apperror.Middleware(k.jwks))
+}
+
+func (k *KeysHandler) jwks(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return json.NewEncoder(w).Encode(k.keys.JWKS())
+}
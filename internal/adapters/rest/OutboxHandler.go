@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"gosmol/internal/apperror"
+	"gosmol/internal/domain"
+	"gosmol/pkg/logging"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type OutboxService interface {
+	GetMessages(status string, limits int64, page int64) ([]domain.OutboxMessage, error)
+	RetryMessage(id int64) error
+}
+
+type OutboxHandler struct {
+	service OutboxService
+	logger  *logging.Logger
+}
+
+func NewOutboxHandler(s OutboxService, l *logging.Logger) *OutboxHandler {
+	return &OutboxHandler{
+		service: s,
+		logger:  l,
+	}
+}
+
+const (
+	outboxURL      = "/api/admin/outbox"
+	outboxRetryURL = "/api/admin/outbox/:id/retry"
+)
+
+func (o *OutboxHandler) Register(router *httprouter.Router, verifier *apperror.Verifier) {
+	router.Handler(http.MethodGet, outboxURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermOutboxManage, http.HandlerFunc(apperror.Middleware(o.get)))))
+	router.Handler(http.MethodPost, outboxRetryURL, verifier.JWTMiddleware(apperror.Authorize(domain.PermOutboxManage, http.HandlerFunc(apperror.Middleware(o.retry)))))
+}
+
+func (o *OutboxHandler) get(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	var limits int64 = 50
+
+	page, err := strconv.ParseInt(r.URL.Query().Get("page"), 10, 64)
+	if err != nil {
+		page = 1
+	}
+
+	messages, err := o.service.GetMessages(r.URL.Query().Get("status"), limits, page)
+	if err != nil {
+		o.logger.Error("Failed to list outbox: " + err.Error())
+		http.Error(w, err.Error(), outboxErrorStatus(err))
+		return err
+	}
+
+	json.NewEncoder(w).Encode(messages)
+	return nil
+}
+
+func (o *OutboxHandler) retry(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		o.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	if err := o.service.RetryMessage(id); err != nil {
+		o.logger.Error("Failed to retry outbox message: " + err.Error())
+		http.Error(w, err.Error(), outboxErrorStatus(err))
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func outboxErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrInvalidOutboxQuery):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrOutboxNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrOutboxNotRetryable):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
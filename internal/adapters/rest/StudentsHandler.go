@@ -2,80 +2,116 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	
+	"net/netip"
+	"strconv"
+	"strings"
+
 	"gosmol/internal/apperror"
 	"gosmol/internal/domain"
 	"gosmol/pkg/logging"
-	
+
 	"github.com/julienschmidt/httprouter"
 )
 
 type StudentsService interface {
 	StudentsRegister(students domain.Student) (domain.Student, error)
-	StudentsLogin(students domain.Student) (domain.TokenResponse, domain.TwoFaCodes, error)
-	StudentsRefresh(token string) (domain.TokenResponse, error)
+	StudentsLogin(students domain.Student, client domain.ClientInfo) (domain.TokenResponse, domain.TwoFaCodes, error)
+	StudentsRefresh(token string, client domain.ClientInfo) (domain.TokenResponse, error)
 	StudentsSendEmailCode(tempToken string) error
-    VerifyCode(code domain.Code) (domain.TokenResponse, error)
-    EnableTwoFA(userID int64) error
-    DisableTwoFA(userID int64, password string) error
+	VerifyCode(code domain.Code, client domain.ClientInfo) (domain.TokenResponse, error)
+	EnableTwoFA(userID int64) ([]string, error)
+	DisableTwoFA(userID int64, password string) error
+	RegenerateRecoveryCodes(userID int64, password string) ([]string, error)
+	EnrollTotp(userID int64) (domain.TotpEnrollment, error)
+	TotpQRCode(userID int64) ([]byte, error)
+	ConfirmTotp(userID int64, code string) ([]string, error)
+	SetTwoFAMethod(userID int64, method string) error
+	ForgotPassword(email string) error
+	ResetPassword(token string, password string) error
+	VerifyEmail(token string) error
+	ResendEmailVerification(email string) error
+	ListSessions(userID int64, currentSessionID string) ([]domain.Session, error)
+	RevokeSession(userID int64, sessionID string) error
+	LogoutAll(userID int64) error
+	SetRole(userID int64, role string) error
 }
 
 type StudentsHandler struct {
 	service StudentsService
-	logger *logging.Logger
+	logger  *logging.Logger
+	// proxies are the reverse proxies whose X-Forwarded-For is believed.
+	proxies []netip.Prefix
 }
 
-func NewStudentsHandler(s StudentsService, l *logging.Logger) *StudentsHandler {
+func NewStudentsHandler(s StudentsService, l *logging.Logger, trustedProxies []netip.Prefix) *StudentsHandler {
 	return &StudentsHandler{
 		service: s,
-		logger: l,
+		logger:  l,
+		proxies: trustedProxies,
 	}
 }
 
 var stud []domain.Student
 
-func (s *StudentsHandler) Register(router *httprouter.Router, jwtSecret string) {
+func (s *StudentsHandler) Register(router *httprouter.Router, verifier *apperror.Verifier) {
 	router.HandlerFunc(http.MethodPost, "/api/auth/register", apperror.Middleware(s.signUp))
 	router.HandlerFunc(http.MethodPost, "/api/auth/login", apperror.Middleware(s.signIn))
 	router.HandlerFunc(http.MethodPost, "/api/auth/refresh", apperror.Middleware(s.refresh))
 	router.HandlerFunc(http.MethodPost, "/api/auth/send-code", apperror.Middleware(s.sendEmailToken))
 	router.HandlerFunc(http.MethodPost, "/api/auth/verify-code", apperror.Middleware(s.verifyCode))
-	router.Handler(http.MethodPost, "/api/auth/enable-2fa", apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(s.enableTwoFA))))
-	router.Handler(http.MethodPost, "/api/auth/disable-2fa", apperror.JWTMiddleware(jwtSecret, http.HandlerFunc(apperror.Middleware(s.disableTwoFA))))
+	router.HandlerFunc(http.MethodPost, "/api/auth/password/forgot", apperror.Middleware(s.forgotPassword))
+	router.HandlerFunc(http.MethodPost, "/api/auth/password/reset", apperror.Middleware(s.resetPassword))
+	router.HandlerFunc(http.MethodGet, "/api/auth/verify-email", apperror.Middleware(s.verifyEmail))
+	router.HandlerFunc(http.MethodPost, "/api/auth/verify-email", apperror.Middleware(s.verifyEmail))
+	router.HandlerFunc(http.MethodPost, "/api/auth/verify-email/resend", apperror.Middleware(s.resendEmailVerification))
+	router.Handler(http.MethodPost, "/api/auth/enable-2fa", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.enableTwoFA)))))
+	router.Handler(http.MethodPost, "/api/auth/disable-2fa", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.disableTwoFA)))))
+	router.Handler(http.MethodPost, "/api/auth/recovery-codes/regenerate", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.regenerateRecoveryCodes)))))
+	router.Handler(http.MethodPost, "/api/auth/2fa-method", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.setTwoFAMethod)))))
+	router.Handler(http.MethodPost, "/api/auth/totp/enroll", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.enrollTotp)))))
+	router.Handler(http.MethodGet, "/api/auth/totp/qr", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.totpQRCode)))))
+	router.Handler(http.MethodPost, "/api/auth/totp/confirm", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.confirmTotp)))))
+	router.Handler(http.MethodGet, "/api/auth/sessions", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.listSessions)))))
+	router.Handler(http.MethodDelete, "/api/auth/sessions/:id", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.revokeSession)))))
+	router.Handler(http.MethodPost, "/api/auth/logout", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.logout)))))
+	router.Handler(http.MethodPost, "/api/auth/logout-all", verifier.JWTMiddleware(apperror.Authorize(domain.PermAccountManage, http.HandlerFunc(apperror.Middleware(s.logoutAll)))))
+	router.Handler(http.MethodPut, "/api/admin/users/:id/role", verifier.JWTMiddleware(apperror.Authorize(domain.PermUsersManage, http.HandlerFunc(apperror.Middleware(s.setRole)))))
 }
 
 func (s *StudentsHandler) signUp(w http.ResponseWriter, r *http.Request) error {
-    w.Header().Set("Content-Type", "application/json")
-    
-    var student domain.Student
-    if err := json.NewDecoder(r.Body).Decode(&student); err != nil {
-        s.logger.Error("Failed to decode JSON: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-    defer r.Body.Close()
-
-    fmt.Printf("DEBUG HANDLER REGISTER: Received - Firstname: %s, Email: %s\n", 
-        student.Firstname, student.Email)
-
-    createdStudent, err := s.service.StudentsRegister(student)
-    if err != nil {
-        s.logger.Error("Failed to register student: " + err.Error())
-        http.Error(w, err.Error(), http.StatusBadRequest)
-        return err
-    }
-
-    fmt.Printf("DEBUG HANDLER REGISTER: Student created with ID: %d\n", createdStudent.ID)
-    
-    w.WriteHeader(http.StatusCreated)
-    return json.NewEncoder(w).Encode(createdStudent)
+	w.Header().Set("Content-Type", "application/json")
+
+	var student domain.Student
+	if err := json.NewDecoder(r.Body).Decode(&student); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	fmt.Printf("DEBUG HANDLER REGISTER: Received - Firstname: %s, Email: %s\n",
+		student.Firstname, student.Email)
+
+	createdStudent, err := s.service.StudentsRegister(student)
+	if err != nil {
+		s.logger.Error("Failed to register student: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	fmt.Printf("DEBUG HANDLER REGISTER: Student created with ID: %d\n", createdStudent.ID)
+
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(createdStudent)
 }
 
 func (s *StudentsHandler) signIn(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Context-Type", "application/json")
-	
+
 	var student domain.Student
 	if err := json.NewDecoder(r.Body).Decode(&student); err != nil {
 		s.logger.Error("Failed to decode JSON: " + err.Error())
@@ -84,8 +120,7 @@ func (s *StudentsHandler) signIn(w http.ResponseWriter, r *http.Request) error {
 	}
 	defer r.Body.Close()
 
-
-	accessToken, tempToken, err := s.service.StudentsLogin(student)
+	accessToken, tempToken, err := s.service.StudentsLogin(student, s.clientInfo(r))
 	if err != nil {
 		s.logger.Error("Failed to login student: " + err.Error())
 		http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -95,14 +130,16 @@ func (s *StudentsHandler) signIn(w http.ResponseWriter, r *http.Request) error {
 	if tempToken.RequiresTwoFa {
 		return json.NewEncoder(w).Encode(tempToken)
 	}
-	
+
 	json.NewEncoder(w).Encode(accessToken)
 	return nil
 }
 
 func (s *StudentsHandler) refresh(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
-	var tokens struct{ RefreshToken string `json:"refresh_token"` }
+	var tokens struct {
+		RefreshToken string `json:"refresh_token"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&tokens); err != nil {
 		s.logger.Error("Failed to decode JSON: " + err.Error())
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
@@ -110,7 +147,7 @@ func (s *StudentsHandler) refresh(w http.ResponseWriter, r *http.Request) error
 	}
 	defer r.Body.Close()
 
-	token, err := s.service.StudentsRefresh(tokens.RefreshToken)
+	token, err := s.service.StudentsRefresh(tokens.RefreshToken, s.clientInfo(r))
 	if err != nil {
 		s.logger.Error("Failed to refresh token: " + err.Error())
 		http.Error(w, err.Error(), http.StatusUnauthorized)
@@ -123,21 +160,23 @@ func (s *StudentsHandler) refresh(w http.ResponseWriter, r *http.Request) error
 
 func (s *StudentsHandler) sendEmailToken(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
-	var req struct { TempToken string `json:"temp_token"` }
+	var req struct {
+		TempToken string `json:"temp_token"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.logger.Error("Failed to decode JSON: " + err.Error())
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return err
 	}
 	defer r.Body.Close()
-	
+
 	err := s.service.StudentsSendEmailCode(req.TempToken)
 	if err != nil {
 		s.logger.Error("Failed to temp token: " + err.Error())
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return err
 	}
-	
+
 	res := map[string]bool{"success": true}
 	json.NewEncoder(w).Encode(res)
 	return nil
@@ -146,55 +185,54 @@ func (s *StudentsHandler) sendEmailToken(w http.ResponseWriter, r *http.Request)
 func (s *StudentsHandler) verifyCode(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
 	var code domain.Code
-	
+
 	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
 		s.logger.Error("Failed to decode JSON: " + err.Error())
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return err
 	}
 	defer r.Body.Close()
-	
-	tokenRes, err := s.service.VerifyCode(code)
+
+	tokenRes, err := s.service.VerifyCode(code, s.clientInfo(r))
 	if err != nil {
 		s.logger.Error("Failed to verify code: " + err.Error())
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return err
 	}
-	
+
 	json.NewEncoder(w).Encode(tokenRes)
 	return nil
 }
 
 func (s *StudentsHandler) enableTwoFA(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
-	
+
 	userID, ok := r.Context().Value("studentID").(int64)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return nil
 	}
-	
-	err := s.service.EnableTwoFA(userID)
+
+	codes, err := s.service.EnableTwoFA(userID)
 	if err != nil {
 		s.logger.Error("Failed to enable 2FA: " + err.Error())
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return err
 	}
-	
-	res := map[string]bool{"success": true}
-	json.NewEncoder(w).Encode(res)
+
+	json.NewEncoder(w).Encode(domain.RecoveryCodes{Codes: codes})
 	return nil
 }
 
 func (s *StudentsHandler) disableTwoFA(w http.ResponseWriter, r *http.Request) error {
 	w.Header().Set("Content-Type", "application/json")
-	
+
 	userID, ok := r.Context().Value("studentID").(int64)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return nil
 	}
-	
+
 	var req domain.TwoFaToggleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.logger.Error("Failed to decode JSON: " + err.Error())
@@ -202,7 +240,7 @@ func (s *StudentsHandler) disableTwoFA(w http.ResponseWriter, r *http.Request) e
 		return err
 	}
 	defer r.Body.Close()
-	
+
 	err := s.service.DisableTwoFA(userID, req.Password)
 	if err != nil {
 		s.logger.Error("Failed to disable 2FA: " + err.Error())
@@ -213,4 +251,408 @@ func (s *StudentsHandler) disableTwoFA(w http.ResponseWriter, r *http.Request) e
 	res := map[string]bool{"success": true}
 	json.NewEncoder(w).Encode(res)
 	return nil
-}
\ No newline at end of file
+}
+
+func (s *StudentsHandler) setTwoFAMethod(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	var req domain.TwoFaMethodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	err := s.service.SetTwoFAMethod(userID, req.Method)
+	if err != nil {
+		s.logger.Error("Failed to set 2FA method: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) enrollTotp(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	enrollment, err := s.service.EnrollTotp(userID)
+	if err != nil {
+		s.logger.Error("Failed to enroll TOTP: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	json.NewEncoder(w).Encode(enrollment)
+	return nil
+}
+
+func (s *StudentsHandler) totpQRCode(w http.ResponseWriter, r *http.Request) error {
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	png, err := s.service.TotpQRCode(userID)
+	if err != nil {
+		s.logger.Error("Failed to render TOTP QR code: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err = w.Write(png)
+	return err
+}
+
+func (s *StudentsHandler) confirmTotp(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	codes, err := s.service.ConfirmTotp(userID, req.Code)
+	if err != nil {
+		s.logger.Error("Failed to confirm TOTP: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	if codes != nil {
+		return json.NewEncoder(w).Encode(domain.RecoveryCodes{Codes: codes})
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	var req domain.TwoFaToggleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	codes, err := s.service.RegenerateRecoveryCodes(userID, req.Password)
+	if err != nil {
+		s.logger.Error("Failed to regenerate recovery codes: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	json.NewEncoder(w).Encode(domain.RecoveryCodes{Codes: codes})
+	return nil
+}
+
+func (s *StudentsHandler) forgotPassword(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	var req domain.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	err := s.service.ForgotPassword(req.Email)
+	if err != nil {
+		s.logger.Error("Failed to request password reset: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) resetPassword(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	var req domain.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	err := s.service.ResetPassword(req.Token, req.Password)
+	if err != nil {
+		s.logger.Error("Failed to reset password: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) verifyEmail(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	req := domain.VerifyEmailRequest{Token: r.URL.Query().Get("token")}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.logger.Error("Failed to decode JSON: " + err.Error())
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return err
+		}
+		defer r.Body.Close()
+	}
+
+	err := s.service.VerifyEmail(req.Token)
+	if err != nil {
+		s.logger.Error("Failed to verify email: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) resendEmailVerification(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	var req domain.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	err := s.service.ResendEmailVerification(req.Email)
+	if err != nil {
+		s.logger.Error("Failed to resend verification email: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) listSessions(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	sessionID, _ := r.Context().Value("sessionID").(string)
+
+	sessions, err := s.service.ListSessions(userID, sessionID)
+	if err != nil {
+		s.logger.Error("Failed to list sessions: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+	return nil
+}
+
+func (s *StudentsHandler) revokeSession(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	params := httprouter.ParamsFromContext(r.Context())
+	err := s.service.RevokeSession(userID, params.ByName("id"))
+	if err != nil {
+		s.logger.Error("Failed to revoke session: " + err.Error())
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return err
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *StudentsHandler) logout(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+	sessionID, _ := r.Context().Value("sessionID").(string)
+
+	err := s.service.RevokeSession(userID, sessionID)
+	if err != nil {
+		s.logger.Error("Failed to logout: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) logoutAll(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := r.Context().Value("studentID").(int64)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	err := s.service.LogoutAll(userID)
+	if err != nil {
+		s.logger.Error("Failed to logout from all sessions: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+func (s *StudentsHandler) setRole(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+
+	params := httprouter.ParamsFromContext(r.Context())
+	userID, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	if err != nil {
+		s.logger.Error("Failed to params: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var req domain.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.logger.Error("Failed to decode JSON: " + err.Error())
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return err
+	}
+	defer r.Body.Close()
+
+	err = s.service.SetRole(userID, req.Role)
+	if err != nil {
+		s.logger.Error("Failed to set role: " + err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	res := map[string]bool{"success": true}
+	json.NewEncoder(w).Encode(res)
+	return nil
+}
+
+// clientInfo describes the device behind a request. X-Forwarded-For is only
+// read when the request comes from a trusted proxy; it is walked from the
+// right and the first address that is not a trusted proxy is the client.
+func (s *StudentsHandler) clientInfo(r *http.Request) domain.ClientInfo {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	if s.trusted(ip) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			ip = hop
+			if !s.trusted(hop) {
+				break
+			}
+		}
+	}
+
+	return domain.ClientInfo{UserAgent: r.UserAgent(), IP: ip}
+}
+
+func (s *StudentsHandler) trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range s.proxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies reads proxy addresses given as single IPs or CIDR
+// ranges.
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(value); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
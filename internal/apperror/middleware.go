@@ -3,16 +3,42 @@ package apperror
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
-	"fmt"
 
-	"github.com/golang-jwt/jwt/v5"
+	"gosmol/internal/domain"
+	"gosmol/pkg/auth"
 )
 
 type appHandler func(w http.ResponseWriter, r *http.Request) error
 
-func JWTMiddleware(jwtSecret string, next http.Handler) http.Handler {
+// SessionChecker tells whether the session an access token belongs to is
+// still active.
+type SessionChecker interface {
+	SessionActive(sessionID string) (bool, error)
+}
+
+// Verifier checks bearer tokens against the expected type, audience and
+// issuer of a route.
+type Verifier struct {
+	keys     *auth.KeySet
+	sessions SessionChecker
+	issuer   string
+	audience string
+}
+
+func NewVerifier(keys *auth.KeySet, sessions SessionChecker, issuer, audience string) *Verifier {
+	return &Verifier{keys: keys, sessions: sessions, issuer: issuer, audience: audience}
+}
+
+// JWTMiddleware accepts only access tokens issued for the API audience whose
+// session has not been revoked.
+func (v *Verifier) JWTMiddleware(next http.Handler) http.Handler {
+	return v.Require(auth.TokenAccess, v.audience, next)
+}
+
+func (v *Verifier) Require(tokenType, audience string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("DEBUG JWT MIDDLEWARE: Checking auth for %s %s\n", r.Method, r.URL.Path)
 		authHeader := r.Header.Get("Authorization")
@@ -21,26 +47,55 @@ func JWTMiddleware(jwtSecret string, next http.Handler) http.Handler {
 			http.Error(w, "Missing token", http.StatusUnauthorized)
 			return
 		}
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		fmt.Printf("DEBUG JWT MIDDLEWARE: Token: %s...\n", tokenString[:10])
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error)  {
-			return []byte(jwtSecret), nil
-		})
+		tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		claims, err := v.keys.Verify(tokenString, tokenType, v.issuer, audience)
 		if err != nil {
+			fmt.Printf("DEBUG JWT MIDDLEWARE: Rejected token: %v\n", err)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return 
+			return
 		}
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Invalid claims", http.StatusUnauthorized)
-			return 
+		if claims.SessionID != "" {
+			active, err := v.sessions.SessionActive(claims.SessionID)
+			if err != nil {
+				fmt.Printf("DEBUG JWT MIDDLEWARE: Session check failed: %v\n", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if !active {
+				fmt.Printf("DEBUG JWT MIDDLEWARE: Session %s revoked\n", claims.SessionID)
+				http.Error(w, "Session revoked", http.StatusUnauthorized)
+				return
+			}
 		}
-		studentID := int64(claims["user_id"].(float64))
-		ctx := context.WithValue(r.Context(), "studentID", studentID)
+		ctx := context.WithValue(r.Context(), "studentID", claims.UserID)
+		ctx = context.WithValue(ctx, "sessionID", claims.SessionID)
+		ctx = context.WithValue(ctx, "role", claims.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// Authorize lets the request through only if the role put in the context by
+// the JWT middleware grants the permission.
+func Authorize(permission domain.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, ok := r.Context().Value("role").(string)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !domain.Can(role, permission) {
+			fmt.Printf("DEBUG AUTHORIZE: role %q lacks %s for %s %s\n", role, permission, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func Middleware(h appHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var appErr *AppError
@@ -60,12 +115,12 @@ func Middleware(h appHandler) http.HandlerFunc {
 				err = err.(*AppError)
 				w.WriteHeader(http.StatusBadRequest)
 				w.Write(appErr.Marshal())
-				return 
-				
+				return
+
 			}
 
 			w.WriteHeader(http.StatusTeapot)
 			w.Write(systemError(err).Marshal())
 		}
 	}
-}
\ No newline at end of file
+}
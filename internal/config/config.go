@@ -3,13 +3,24 @@ package config
 import (
 	"gosmol/pkg/logging"
 	"sync"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	Env         string `yaml:"env" env-default:"development"`
+	Env       string `yaml:"env" env-default:"development"`
+	PublicURL string `yaml:"public_url" env:"APP_PUBLIC_URL" env-default:"http://localhost:8888"`
+	// TrustedProxies are the IPs or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header is used for the client address.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" env-separator:","`
 	StorageConfig
+	Email   EmailConfig       `yaml:"email"`
+	Outbox  OutboxConfig      `yaml:"outbox"`
+	Auth    AuthConfig        `yaml:"auth"`
+	Files   FileStorageConfig `yaml:"files"`
+	Listing ListingConfig     `yaml:"listing"`
+	Trash   TrashConfig       `yaml:"trash"`
 }
 
 type StorageConfig struct {
@@ -20,6 +31,58 @@ type StorageConfig struct {
 	Password string `yaml:"password" env:"DB_PASSWORD" env-default:"postgres"`
 }
 
+type EmailConfig struct {
+	Driver     string `yaml:"driver" env:"EMAIL_DRIVER" env-default:"file"`
+	From       string `yaml:"from" env:"EMAIL_FROM" env-default:"no-reply@gosmol.local"`
+	MailboxDir string `yaml:"mailbox_dir" env:"EMAIL_MAILBOX_DIR" env-default:"/tmp/mailbox"`
+	Host       string `yaml:"host" env:"SMTP_HOST"`
+	Port       int    `yaml:"port" env:"SMTP_PORT" env-default:"587"`
+	Username   string `yaml:"username" env:"SMTP_USERNAME"`
+	Password   string `yaml:"password" env:"SMTP_PASSWORD"`
+	Encryption string `yaml:"encryption" env:"SMTP_ENCRYPTION" env-default:"starttls"`
+}
+
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" env:"OUTBOX_POLL_INTERVAL" env-default:"5s"`
+	BatchSize    int           `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE" env-default:"20"`
+	BaseBackoff  time.Duration `yaml:"base_backoff" env:"OUTBOX_BASE_BACKOFF" env-default:"30s"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" env:"OUTBOX_MAX_BACKOFF" env-default:"1h"`
+}
+
+type FileStorageConfig struct {
+	Driver       string   `yaml:"driver" env:"FILES_DRIVER" env-default:"local"`
+	Dir          string   `yaml:"dir" env:"FILES_DIR" env-default:"/tmp/files"`
+	Endpoint     string   `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Bucket       string   `yaml:"bucket" env:"S3_BUCKET" env-default:"diplomas"`
+	AccessKey    string   `yaml:"access_key" env:"S3_ACCESS_KEY"`
+	SecretKey    string   `yaml:"secret_key" env:"S3_SECRET_KEY"`
+	Region       string   `yaml:"region" env:"S3_REGION"`
+	UseSSL       bool     `yaml:"use_ssl" env:"S3_USE_SSL" env-default:"false"`
+	MaxSize      int64    `yaml:"max_size" env:"FILES_MAX_SIZE" env-default:"52428800"`
+	AllowedTypes []string `yaml:"allowed_types" env:"FILES_ALLOWED_TYPES" env-separator:"," env-default:"application/pdf,application/zip"`
+}
+
+// ListingConfig holds the key that signs pagination cursors. Without one a
+// random key is used and cursors do not survive a restart.
+type ListingConfig struct {
+	CursorSecret string `yaml:"cursor_secret" env:"CURSOR_SECRET"`
+}
+
+type TrashConfig struct {
+	Retention     time.Duration `yaml:"retention" env:"TRASH_RETENTION" env-default:"720h"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"TRASH_PURGE_INTERVAL" env-default:"1h"`
+}
+
+type AuthConfig struct {
+	RequireEmailVerification bool          `yaml:"require_email_verification" env:"REQUIRE_EMAIL_VERIFICATION" env-default:"false"`
+	KeysDir                  string        `yaml:"keys_dir" env:"JWT_KEYS_DIR" env-default:"/tmp/keys"`
+	Algorithm                string        `yaml:"algorithm" env:"JWT_ALGORITHM" env-default:"RS256"`
+	RotationInterval         time.Duration `yaml:"rotation_interval" env:"JWT_ROTATION_INTERVAL" env-default:"720h"`
+	KeyRetention             time.Duration `yaml:"key_retention" env:"JWT_KEY_RETENTION" env-default:"336h"`
+	Issuer                   string        `yaml:"issuer" env:"JWT_ISSUER" env-default:"gosmol"`
+	Audience                 string        `yaml:"audience" env:"JWT_AUDIENCE" env-default:"gosmol-api"`
+}
+
 var instance *Config
 var once sync.Once
 
@@ -32,12 +95,12 @@ func GetConfig() *Config {
 		if err := cleanenv.ReadEnv(instance); err != nil {
 			logger.Errorf("Error reading env vars: %v", err)
 		}
-		
+
 		if err := cleanenv.ReadConfig("/config.yml", instance); err != nil {
 			logger.Warnf("Config file not found, using env vars: %v", err)
 		}
-		
+
 		logger.Infof("Database config: %s:%s", instance.Host, instance.Port)
 	})
 	return instance
-}
\ No newline at end of file
+}
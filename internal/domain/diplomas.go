@@ -1,8 +1,197 @@
 package domain
 
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidTransition = errors.New("transition is not allowed from the current status")
+	ErrDiplomaLocked     = errors.New("diploma can only be edited while it is a draft")
+	ErrReasonRequired    = errors.New("reason is required")
+	ErrSearchTooLong     = errors.New("search query is too long")
+	ErrInvalidQuery      = errors.New("invalid query parameter")
+	ErrDiplomaNotFound   = errors.New("diploma not found")
+	ErrVersionMismatch   = errors.New("diploma was changed since it was read")
+	ErrUnsupportedPatch  = errors.New("unsupported patch format")
+	ErrInvalidPatch      = errors.New("invalid patch")
+)
+
+const (
+	DiplomaStatusDraft       = "draft"
+	DiplomaStatusSubmitted   = "submitted"
+	DiplomaStatusUnderReview = "under_review"
+	DiplomaStatusApproved    = "approved"
+	DiplomaStatusDefended    = "defended"
+)
+
+const (
+	DiplomaActionSubmit  = "submit"
+	DiplomaActionReview  = "review"
+	DiplomaActionApprove = "approve"
+	DiplomaActionReject  = "reject"
+	DiplomaActionDefend  = "defend"
+)
+
+// Patch formats accepted for partial updates, named by their media types.
+const (
+	MergePatch = "application/merge-patch+json"
+	JSONPatch  = "application/json-patch+json"
+)
+
+// DiplomaTransition is one edge of the diploma lifecycle.
+type DiplomaTransition struct {
+	From       []string
+	To         string
+	Permission Permission
+	// OwnerOnly restricts the action to the diploma owner (admins excepted).
+	OwnerOnly bool
+	// NeedsReason requires a comment explaining the decision.
+	NeedsReason bool
+}
+
+// DiplomaTransitions is the lifecycle state machine:
+// draft -> submitted -> under_review -> approved -> defended. A rejection
+// sends a submitted or reviewed diploma back to draft.
+var DiplomaTransitions = map[string]DiplomaTransition{
+	DiplomaActionSubmit: {
+		From:       []string{DiplomaStatusDraft},
+		To:         DiplomaStatusSubmitted,
+		Permission: PermDiplomaUpdate,
+		OwnerOnly:  true,
+	},
+	DiplomaActionReview: {
+		From:       []string{DiplomaStatusSubmitted},
+		To:         DiplomaStatusUnderReview,
+		Permission: PermDiplomaApprove,
+	},
+	DiplomaActionApprove: {
+		From:       []string{DiplomaStatusUnderReview},
+		To:         DiplomaStatusApproved,
+		Permission: PermDiplomaApprove,
+	},
+	DiplomaActionReject: {
+		From:        []string{DiplomaStatusSubmitted, DiplomaStatusUnderReview},
+		To:          DiplomaStatusDraft,
+		Permission:  PermDiplomaApprove,
+		NeedsReason: true,
+	},
+	DiplomaActionDefend: {
+		From:       []string{DiplomaStatusApproved},
+		To:         DiplomaStatusDefended,
+		Permission: PermDiplomaApprove,
+	},
+}
+
+func (t DiplomaTransition) AllowedFrom(status string) bool {
+	for _, from := range t.From {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
 type Diploma struct {
-	ID          int64    `json:"id"`
-	Title       string   `json:"title"`
-	Description string   `json:"description"`
-	Student     *Student `json:"student"`
-}
\ No newline at end of file
+	ID           int64           `json:"id"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	Status       string          `json:"status"`
+	OwnerID      int64           `json:"owner_id"`
+	Student      *StudentProfile `json:"student"`
+	SupervisorID *int64          `json:"supervisor_id"`
+	FinalGrade   *int            `json:"final_grade"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	// Version grows with every change and is sent as the ETag.
+	Version int64 `json:"version"`
+	// DeletedAt is set while the diploma is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Rank and Headline are only set on full-text search results.
+	Rank     *float64 `json:"rank,omitempty"`
+	Headline string   `json:"headline,omitempty"`
+}
+
+const (
+	DefaultPageLimit int64 = 25
+	MaxPageLimit     int64 = 100
+)
+
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// DiplomaSortFields are the fields a listing may be sorted by. Rank is only
+// available together with a search query, deleted_at only in the trash.
+var DiplomaSortFields = []string{"id", "title", "status", "created_at", "updated_at", "rank", "deleted_at"}
+
+// DiplomaQuery describes a page of the diploma listing. A non-empty Q turns
+// it into a full-text search ordered by relevance unless Sort says otherwise.
+// Zero filter values are not applied.
+type DiplomaQuery struct {
+	Q            string
+	Status       string
+	OwnerID      int64
+	SupervisorID int64
+	CreatedFrom  *time.Time
+	CreatedTo    *time.Time
+	// Deleted lists the trash instead of live diplomas.
+	Deleted bool
+	Sort    string
+	Order   string
+	Page    int64
+	Limit   int64
+	// Cursor is the token of the previous page's next_cursor. It switches
+	// the listing from offset to keyset pagination and excludes Page.
+	Cursor string
+	// After is the decoded Cursor the storage continues from.
+	After *DiplomaCursor
+}
+
+// DiplomaCursor is the position after the last row of a page: its sort key
+// and id. Filter is a fingerprint of the filters the cursor was issued for,
+// Sort and Order pin the ordering, so a cursor cannot be reused elsewhere.
+type DiplomaCursor struct {
+	Sort   string `json:"s"`
+	Order  string `json:"o"`
+	Key    string `json:"k"`
+	ID     int64  `json:"i"`
+	Filter string `json:"f"`
+}
+
+// DiplomaPage is one page of a listing. Next and Prev are links to the
+// neighbouring pages, null at either end. Total and Page are only known in
+// offset mode; keyset pages only link forward.
+type DiplomaPage struct {
+	Items      []Diploma `json:"items"`
+	Total      *int64    `json:"total,omitempty"`
+	Page       int64     `json:"page,omitempty"`
+	Limit      int64     `json:"limit"`
+	NextCursor *string   `json:"next_cursor"`
+	Next       *string   `json:"next"`
+	Prev       *string   `json:"prev"`
+}
+
+// StudentProfile is the public part of a student shown next to their
+// diplomas. It never carries password or 2FA fields.
+type StudentProfile struct {
+	ID        int64  `json:"id"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Email     string `json:"email"`
+}
+
+type DiplomaStatusChange struct {
+	ID         int64     `json:"id"`
+	DiplomaID  int64     `json:"diploma_id"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    int64     `json:"actor_id"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type TransitionRequest struct {
+	Reason string `json:"reason"`
+}
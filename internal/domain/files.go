@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrFileNotFound   = errors.New("file not found")
+	ErrFileTooLarge   = errors.New("file is too large")
+	ErrFileTypeDenied = errors.New("file type is not allowed")
+	ErrFileEmpty      = errors.New("file is empty")
+)
+
+type DiplomaFile struct {
+	ID          int64     `json:"id"`
+	DiplomaID   int64     `json:"diploma_id"`
+	StorageKey  string    `json:"-"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UploadedBy  int64     `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
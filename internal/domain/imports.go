@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrImportRejected = errors.New("import has invalid rows, nothing was imported")
+
+// ImportRow is one diploma topic read from an import file. Line is the line
+// or sheet row it came from, so errors can point back at it.
+type ImportRow struct {
+	Line            int
+	Title           string
+	Description     string
+	StudentEmail    string
+	SupervisorEmail string
+}
+
+// ImportUser is a user referenced by an import file, with the supervisor
+// load needed to check capacity.
+type ImportUser struct {
+	ID       int64
+	Email    string
+	Role     string
+	Capacity int
+	Assigned int
+}
+
+type ImportError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e ImportError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+}
+
+// ImportReport describes an import run. IDs are only filled when the rows
+// were committed.
+type ImportReport struct {
+	Rows     int           `json:"rows"`
+	Imported int           `json:"imported"`
+	DryRun   bool          `json:"dry_run"`
+	IDs      []int64       `json:"ids,omitempty"`
+	Errors   []ImportError `json:"errors,omitempty"`
+}
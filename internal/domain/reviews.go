@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrGradingFinalized = errors.New("grading of this diploma is finalized, reviews are locked")
+	ErrNoReviews        = errors.New("diploma has no reviews to grade")
+	ErrNotReviewable    = errors.New("diploma is not under review")
+)
+
+// Grades on the national 5-point scale; 2 is a fail.
+const (
+	GradeMin = 2
+	GradeMax = 5
+)
+
+type ReviewCriterion struct {
+	Key    string  `json:"key"`
+	Title  string  `json:"title"`
+	Weight float64 `json:"weight"`
+}
+
+// Rubric lists the criteria every review scores. The weights add up to 1.
+var Rubric = []ReviewCriterion{
+	{Key: "relevance", Title: "Актуальность и постановка задачи", Weight: 0.2},
+	{Key: "methodology", Title: "Методология и обзор источников", Weight: 0.25},
+	{Key: "results", Title: "Практические результаты", Weight: 0.35},
+	{Key: "presentation", Title: "Оформление и изложение", Weight: 0.2},
+}
+
+type CriterionScore struct {
+	Criterion string `json:"criterion"`
+	Score     int    `json:"score"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+type Review struct {
+	ID            int64            `json:"id"`
+	DiplomaID     int64            `json:"diploma_id"`
+	ReviewerID    int64            `json:"reviewer_id"`
+	Grade         int              `json:"grade"`
+	Comment       string           `json:"comment"`
+	Scores        []CriterionScore `json:"scores"`
+	WeightedScore float64          `json:"weighted_score"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+type ReviewInput struct {
+	Grade   int              `json:"grade"`
+	Comment string           `json:"comment"`
+	Scores  []CriterionScore `json:"scores"`
+}
+
+// ReviewSummary is a diploma's reviews with their aggregate. The averages
+// are nil until there is at least one review.
+type ReviewSummary struct {
+	Rubric        []ReviewCriterion `json:"rubric"`
+	Reviews       []Review          `json:"reviews"`
+	AverageGrade  *float64          `json:"average_grade"`
+	WeightedScore *float64          `json:"weighted_score"`
+	FinalGrade    *int              `json:"final_grade"`
+	FinalizedAt   *time.Time        `json:"finalized_at"`
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+
+	// Status, supervisor and grade changes bump the version too, so they
+	// get a revision even though title and description stay the same.
+	RevisionTransition = "transition"
+	RevisionSupervise  = "supervise"
+	RevisionGrade      = "grade"
+)
+
+// DiplomaRevision is a snapshot of a diploma right after a change. Version is
+// the diploma version the change produced, the same number sent as ETag.
+type DiplomaRevision struct {
+	ID          int64     `json:"id"`
+	DiplomaID   int64     `json:"diploma_id"`
+	Version     int64     `json:"version"`
+	Action      string    `json:"action"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	AuthorID    int64     `json:"author_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type DiffChunk struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiplomaDiff is the word-level difference between two revisions.
+type DiplomaDiff struct {
+	DiplomaID   int64       `json:"diploma_id"`
+	From        int64       `json:"from"`
+	To          int64       `json:"to"`
+	Title       []DiffChunk `json:"title"`
+	Description []DiffChunk `json:"description"`
+}
@@ -0,0 +1,76 @@
+package domain
+
+import "errors"
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	ErrForbidden   = errors.New("forbidden")
+)
+
+const (
+	RoleStudent    = "student"
+	RoleSupervisor = "supervisor"
+	RoleAdmin      = "admin"
+)
+
+type Permission string
+
+const (
+	PermDiplomaRead    Permission = "diploma:read"
+	PermDiplomaCreate  Permission = "diploma:create"
+	PermDiplomaUpdate  Permission = "diploma:update"
+	PermDiplomaDelete  Permission = "diploma:delete"
+	PermDiplomaApprove Permission = "diploma:approve"
+	PermSupervise      Permission = "diploma:supervise"
+	PermDiplomaReview  Permission = "diploma:review"
+	PermDiplomaExport  Permission = "diploma:export"
+	PermGradeFinalize  Permission = "grade:finalize"
+	PermAccountManage  Permission = "account:manage"
+	PermUsersManage    Permission = "users:manage"
+	PermOutboxManage   Permission = "outbox:manage"
+)
+
+// permissions is the role matrix. Students may update diplomas, but only
+// their own, and supervisors approve only the diplomas assigned to them; these
+// checks happen in the service. Exports carry every student's contact data
+// and are left to admins.
+var permissions = map[string][]Permission{
+	RoleStudent: {
+		PermDiplomaRead, PermDiplomaCreate, PermDiplomaUpdate,
+		PermAccountManage,
+	},
+	RoleSupervisor: {
+		PermDiplomaRead, PermDiplomaApprove, PermSupervise, PermDiplomaReview,
+		PermAccountManage,
+	},
+	RoleAdmin: {
+		PermDiplomaRead, PermDiplomaCreate, PermDiplomaUpdate, PermDiplomaDelete, PermDiplomaApprove,
+		PermDiplomaReview, PermGradeFinalize, PermDiplomaExport,
+		PermAccountManage, PermUsersManage, PermOutboxManage,
+	},
+}
+
+// Can reports whether role grants the permission. Unknown roles grant nothing.
+func Can(role string, permission Permission) bool {
+	for _, p := range permissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func ValidRole(role string) bool {
+	_, ok := permissions[role]
+	return ok
+}
+
+// Actor is the authenticated user a service call is made on behalf of.
+type Actor struct {
+	ID   int64
+	Role string
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
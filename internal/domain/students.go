@@ -1,53 +1,126 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("Invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, all sessions of this login were revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
 
 type Student struct {
-	ID           int64     `json:"id"`
-	Firstname    string    `json:"firstname"`
-	Lastname     string    `json:"lastname"`
-	Email        string    `json:"email"`
-	Password     string    `json:"password"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	TwoFAEnabled bool      `json:"two_fa_enabled"`
+	ID            int64     `json:"id"`
+	Firstname     string    `json:"firstname"`
+	Lastname      string    `json:"lastname"`
+	Email         string    `json:"email"`
+	Password      string    `json:"password"`
+	PasswordHash  string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+	TwoFAEnabled  bool      `json:"two_fa_enabled"`
+	TwoFAMethod   string    `json:"two_fa_method"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
 }
 
+const (
+	TwoFaMethodEmail    = "email"
+	TwoFaMethodTotp     = "totp"
+	TwoFaMethodRecovery = "recovery"
+)
+
 type TwoFaCodes struct {
 	RequiresTwoFa bool   `json:"requires_two_fa"`
 	TempToken     string `json:"temp_token"`
+	Method        string `json:"method"`
 }
 
 type TwoFaCode struct {
-	ID            int64     `json:"id"`
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Attempts  int       `json:"attempts"`
+	IsUsed    bool      `json:"is_used"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TotpSecret struct {
 	UserID        int64     `json:"user_id"`
-    Code          string    `json:"code"`
-	ExpiresAt     time.Time `json:"expires_at"`
-    Attempts      int       `json:"attempts"`
-    IsUsed        bool      `json:"is_used"`
-    CreatedAt     time.Time `json:"created_at"`
+	Secret        string    `json:"-"`
+	Confirmed     bool      `json:"confirmed"`
+	LastUsedStep  int64     `json:"-"`
+	Attempts      int       `json:"attempts"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
 }
 
 type Code struct {
 	TempToken string `json:"temp_token"`
 	Code      string `json:"code"`
+	Method    string `json:"method"`
+}
+
+type TwoFaMethodRequest struct {
+	Method string `json:"method"`
 }
 
 type LoginRequest struct {
-    Email    string `json:"email"`
-    Password string `json:"password"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 type RegisterRequest struct {
-    Email    string `json:"email"`
-    Password string `json:"password"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
 }
 
 type TokenResponse struct {
-    AccessToken  string `json:"access_token"`
-    RefreshToken string `json:"refresh_token"`
+	AccessToken            string `json:"access_token"`
+	RefreshToken           string `json:"refresh_token"`
+	RecoveryCodesRemaining *int   `json:"recovery_codes_remaining,omitempty"`
+	Warning                string `json:"warning,omitempty"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
 }
 
 type TwoFaToggleRequest struct {
 	Password string `json:"password"`
-}
\ No newline at end of file
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// ClientInfo describes the device a session was started from.
+type ClientInfo struct {
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSupervisorNotFound = errors.New("supervisor not found")
+	ErrCapacityReached    = errors.New("supervisor has no free places")
+	ErrAlreadySupervised  = errors.New("diploma already has a supervisor")
+	ErrRequestPending     = errors.New("diploma already has a pending supervision request")
+	ErrRequestNotFound    = errors.New("supervision request not found")
+	ErrRequestDecided     = errors.New("supervision request was already decided")
+)
+
+const (
+	SupervisionPending  = "pending"
+	SupervisionAccepted = "accepted"
+	SupervisionDeclined = "declined"
+)
+
+// Supervisor is a user with the supervisor role together with their load.
+// Diplomas that were already defended do not count against the capacity.
+type Supervisor struct {
+	StudentProfile
+	Capacity int `json:"capacity"`
+	Assigned int `json:"assigned"`
+}
+
+type SupervisionRequest struct {
+	ID           int64      `json:"id"`
+	DiplomaID    int64      `json:"diploma_id"`
+	DiplomaTitle string     `json:"diploma_title"`
+	StudentID    int64      `json:"student_id"`
+	SupervisorID int64      `json:"supervisor_id"`
+	Status       string     `json:"status"`
+	Message      string     `json:"message,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+}
+
+type SupervisionRequestInput struct {
+	SupervisorID int64  `json:"supervisor_id"`
+	Message      string `json:"message"`
+}
+
+type CapacityRequest struct {
+	Capacity int `json:"capacity"`
+}
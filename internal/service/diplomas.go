@@ -1,35 +1,269 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"gosmol/internal/domain"
+	"gosmol/pkg/cursor"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DiplomasStorage interface {
-	SelectAllResource(limits int64, page int64) ([]domain.Diploma, error)
+	SelectAllResource(query domain.DiplomaQuery) ([]domain.Diploma, int64, error)
+	SelectResourcesAfter(query domain.DiplomaQuery) ([]domain.Diploma, error)
+	ExportResources(query domain.DiplomaQuery, emit func(domain.Diploma) error) error
 	SelectResource(id int64) (domain.Diploma, error)
+	SelectResourcesByOwner(ownerID int64) ([]domain.Diploma, error)
 	InsertResource(diploma domain.Diploma) (int64, error)
-	RenovationResource(id int64, diploma domain.Diploma) (domain.Diploma, error)
-	DestroyResource(id int64) error
+	RenovationResource(id int64, diploma domain.Diploma, ifMatch []int64, actorID int64, action string) (domain.Diploma, error)
+	DestroyResource(id int64, ifMatch []int64, actorID int64) error
+	RestoreResource(id int64, actorID int64) error
+	SelectRevisions(diplomaID int64) ([]domain.DiplomaRevision, error)
+	SelectRevision(diplomaID int64, version int64) (domain.DiplomaRevision, error)
+	TransitionResource(id int64, from string, to string, actorID int64, reason string) error
+	SelectStatusHistory(id int64) ([]domain.DiplomaStatusChange, error)
+	SelectSupervisors() ([]domain.Supervisor, error)
+	SelectSupervisor(id int64) (domain.Supervisor, error)
+	RenovationSupervisorCapacity(id int64, capacity int) error
+	SelectResourcesBySupervisor(supervisorID int64) ([]domain.Diploma, error)
+	InsertSupervisionRequest(request domain.SupervisionRequest) (int64, error)
+	SelectSupervisionRequest(id int64) (domain.SupervisionRequest, error)
+	SelectSupervisionRequests(supervisorID int64, status string) ([]domain.SupervisionRequest, error)
+	AcceptSupervisionRequest(id int64) error
+	DeclineSupervisionRequest(id int64, reason string) error
+	UpsertReview(review domain.Review) (int64, error)
+	SelectReviews(diplomaID int64) ([]domain.Review, error)
+	SelectGrading(diplomaID int64) (*int, *time.Time, error)
+	FinalizeGrading(diplomaID int64, actorID int64) (int, error)
 }
 
 type Diplomas struct {
 	storage DiplomasStorage
+	cursors *cursor.Signer
 }
 
-func NewDiplomas(storage DiplomasStorage) *Diplomas{
-	return &Diplomas{storage: storage}
+func NewDiplomas(storage DiplomasStorage, cursors *cursor.Signer) *Diplomas {
+	return &Diplomas{storage: storage, cursors: cursors}
 }
 
-func (d *Diplomas) GetResources(limits int64) ([]domain.Diploma, error) {
-	var page int64 = 10
-	diplomas, err := d.storage.SelectAllResource(limits, page)
+const maxSearchLength = 200
+
+// GetResources returns one page of the listing. The limit is capped at
+// domain.MaxPageLimit; a search without an explicit sort is ordered by rank.
+// With a cursor the page continues after it, otherwise Page selects an offset.
+// Both modes hand out a next cursor while more rows follow.
+func (d *Diplomas) GetResources(query domain.DiplomaQuery) (domain.DiplomaPage, error) {
+	query, err := normalizeQuery(query)
+	if err != nil {
+		return domain.DiplomaPage{}, err
+	}
+
+	if query.Cursor != "" {
+		return d.getResourcesAfter(query)
+	}
+
+	diplomas, total, err := d.storage.SelectAllResource(query)
+	if err != nil {
+		return domain.DiplomaPage{}, err
+	}
+
+	page := domain.DiplomaPage{
+		Items: diplomas,
+		Total: &total,
+		Page:  query.Page,
+		Limit: query.Limit,
+	}
+	if query.Page*query.Limit < total && len(diplomas) > 0 {
+		page.NextCursor, err = d.nextCursor(query, diplomas[len(diplomas)-1])
+		if err != nil {
+			return domain.DiplomaPage{}, err
+		}
+	}
+
+	return page, nil
+}
+
+func (d *Diplomas) getResourcesAfter(query domain.DiplomaQuery) (domain.DiplomaPage, error) {
+	var after domain.DiplomaCursor
+	if err := d.cursors.Decode(query.Cursor, &after); err != nil {
+		return domain.DiplomaPage{}, fmt.Errorf("%w: %v", domain.ErrInvalidQuery, err)
+	}
+	if after.Sort != query.Sort || after.Order != query.Order || after.Filter != filterFingerprint(query) {
+		return domain.DiplomaPage{}, fmt.Errorf("%w: cursor was issued for a different query", domain.ErrInvalidQuery)
+	}
+	query.After = &after
+
+	// One extra row tells whether another page follows without counting.
+	limit := query.Limit
+	query.Limit++
+	diplomas, err := d.storage.SelectResourcesAfter(query)
+	if err != nil {
+		return domain.DiplomaPage{}, err
+	}
+
+	page := domain.DiplomaPage{Items: diplomas, Limit: limit}
+	if int64(len(diplomas)) > limit {
+		page.Items = diplomas[:limit]
+		page.NextCursor, err = d.nextCursor(query, page.Items[limit-1])
+		if err != nil {
+			return domain.DiplomaPage{}, err
+		}
+	}
+
+	return page, nil
+}
+
+// ExportResources passes every diploma matching the listing filters to emit,
+// in listing order. Pagination parameters do not apply to an export.
+func (d *Diplomas) ExportResources(query domain.DiplomaQuery, emit func(domain.Diploma) error) error {
+	query.Page, query.Limit, query.Cursor = 0, 0, ""
+	query, err := normalizeQuery(query)
+	if err != nil {
+		return err
+	}
+
+	return d.storage.ExportResources(query, emit)
+}
+
+func normalizeQuery(query domain.DiplomaQuery) (domain.DiplomaQuery, error) {
+	query.Q = strings.TrimSpace(query.Q)
+	if len([]rune(query.Q)) > maxSearchLength {
+		return query, domain.ErrSearchTooLong
+	}
+
+	if query.Cursor != "" && query.Page != 0 {
+		return query, fmt.Errorf("%w: page cannot be combined with cursor", domain.ErrInvalidQuery)
+	}
+	if query.Cursor == "" && query.Page < 1 {
+		query.Page = 1
+	}
+	if query.Limit < 1 {
+		query.Limit = domain.DefaultPageLimit
+	}
+	if query.Limit > domain.MaxPageLimit {
+		query.Limit = domain.MaxPageLimit
+	}
+
+	if query.Sort == "" {
+		query.Sort = "id"
+		if query.Q != "" {
+			query.Sort = "rank"
+			if query.Order == "" {
+				query.Order = domain.SortDesc
+			}
+		}
+	}
+	if !validSort(query.Sort) || (query.Sort == "rank" && query.Q == "") || (query.Sort == "deleted_at" && !query.Deleted) {
+		return query, fmt.Errorf("%w: cannot sort by %q", domain.ErrInvalidQuery, query.Sort)
+	}
+
+	switch query.Order {
+	case "":
+		query.Order = domain.SortAsc
+	case domain.SortAsc, domain.SortDesc:
+	default:
+		return query, fmt.Errorf("%w: order must be asc or desc", domain.ErrInvalidQuery)
+	}
+
+	if query.Status != "" && !validStatus(query.Status) {
+		return query, fmt.Errorf("%w: unknown status %q", domain.ErrInvalidQuery, query.Status)
+	}
+	if query.CreatedFrom != nil && query.CreatedTo != nil && !query.CreatedFrom.Before(*query.CreatedTo) {
+		return query, fmt.Errorf("%w: created_from must be before created_to", domain.ErrInvalidQuery)
+	}
+
+	return query, nil
+}
+
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
+
+// nextCursor signs the position of last, the final row of a page.
+func (d *Diplomas) nextCursor(query domain.DiplomaQuery, last domain.Diploma) (*string, error) {
+	var key string
+	switch query.Sort {
+	case "title":
+		key = last.Title
+	case "status":
+		key = last.Status
+	case "created_at":
+		key = last.CreatedAt.Format(cursorTimeLayout)
+	case "updated_at":
+		key = last.UpdatedAt.Format(cursorTimeLayout)
+	case "deleted_at":
+		if last.DeletedAt != nil {
+			key = last.DeletedAt.Format(cursorTimeLayout)
+		}
+	case "rank":
+		if last.Rank != nil {
+			key = strconv.FormatFloat(*last.Rank, 'g', -1, 64)
+		}
+	default:
+		key = strconv.FormatInt(last.ID, 10)
+	}
+
+	token, err := d.cursors.Encode(domain.DiplomaCursor{
+		Sort:   query.Sort,
+		Order:  query.Order,
+		Key:    key,
+		ID:     last.ID,
+		Filter: filterFingerprint(query),
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &token, nil
+}
+
+// filterFingerprint identifies the filters of a query, so a cursor is only
+// accepted for the listing it came from.
+func filterFingerprint(query domain.DiplomaQuery) string {
+	var from, to string
+	if query.CreatedFrom != nil {
+		from = query.CreatedFrom.UTC().Format(time.RFC3339Nano)
+	}
+	if query.CreatedTo != nil {
+		to = query.CreatedTo.UTC().Format(time.RFC3339Nano)
+	}
 
-	return diplomas, nil
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%s\x00%s\x00%t",
+		query.Q, query.Status, query.OwnerID, query.SupervisorID, from, to, query.Deleted)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// matchesVersion reports whether version is one of ifMatch. An empty list
+// means the client set no precondition.
+func matchesVersion(version int64, ifMatch []int64) bool {
+	if len(ifMatch) == 0 {
+		return true
+	}
+	for _, v := range ifMatch {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func validSort(field string) bool {
+	for _, f := range domain.DiplomaSortFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func validStatus(status string) bool {
+	switch status {
+	case domain.DiplomaStatusDraft, domain.DiplomaStatusSubmitted, domain.DiplomaStatusUnderReview,
+		domain.DiplomaStatusApproved, domain.DiplomaStatusDefended:
+		return true
+	}
+	return false
 }
 
 func (d *Diplomas) GetResource(id int64) (domain.Diploma, error) {
@@ -41,60 +275,156 @@ func (d *Diplomas) GetResource(id int64) (domain.Diploma, error) {
 	return diploma, nil
 }
 
+func (d *Diplomas) GetStudentResources(studentID int64) ([]domain.Diploma, error) {
+	return d.storage.SelectResourcesByOwner(studentID)
+}
+
 func (d *Diplomas) CreateResource(diploma domain.Diploma) (domain.Diploma, error) { // меняем возвращаемое значение
-    if diploma.Title == "" {
-        return domain.Diploma{}, errors.New("Title invalid")
-    }
-    if len(diploma.Description) > 500 {
-        return domain.Diploma{}, errors.New("Description too long")
-    }
-
-    fmt.Printf("DEBUG SERVICE DIPLOMA CREATE: Calling storage.InsertResource\n")
-    id, err := d.storage.InsertResource(diploma)
-    if err != nil {
-        fmt.Printf("DEBUG SERVICE DIPLOMA CREATE: Storage error: %v\n", err)
-        return domain.Diploma{}, err
-    }
-
-    createdDiploma := domain.Diploma{
-        ID:          id,
-        Title:       diploma.Title,
-        Description: diploma.Description,
-    }
-    
-    fmt.Printf("DEBUG SERVICE DIPLOMA CREATE: SUCCESS - Created diploma with ID: %d\n", id)
-    return createdDiploma, nil
-}
-
-func (d *Diplomas) UpdateResource(id int64, diploma domain.Diploma) (domain.Diploma, error) {
-    if id == 0 {
-        return domain.Diploma{}, errors.New("id invalid")
-    }
-    if diploma.Title == "" {
-        return domain.Diploma{}, errors.New("Title invalid")
-    }
-    if len(diploma.Description) > 500 {
-        return domain.Diploma{}, errors.New("Description too long")
-    }
-
-    fmt.Printf("DEBUG SERVICE DIPLOMA UPDATE: Calling storage.RenovationResource\n")
-    updatedDiploma, err := d.storage.RenovationResource(id, diploma)
-    if err != nil {
-        fmt.Printf("DEBUG SERVICE DIPLOMA UPDATE: Storage error: %v\n", err)
-        return domain.Diploma{}, err
-    }
-    
-    updatedDiploma.ID = id
-    
-    fmt.Printf("DEBUG SERVICE DIPLOMA UPDATE: SUCCESS - Updated diploma with ID: %d\n", id)
-    return updatedDiploma, nil
-}
-
-func (d *Diplomas) DeleteResource(id int64) error {
-	err := d.storage.DestroyResource(id)
+	if err := validateDiploma(diploma); err != nil {
+		return domain.Diploma{}, err
+	}
+
+	fmt.Printf("DEBUG SERVICE DIPLOMA CREATE: Calling storage.InsertResource\n")
+	id, err := d.storage.InsertResource(diploma)
+	if err != nil {
+		fmt.Printf("DEBUG SERVICE DIPLOMA CREATE: Storage error: %v\n", err)
+		return domain.Diploma{}, err
+	}
+
+	createdDiploma, err := d.storage.SelectResource(id)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+
+	fmt.Printf("DEBUG SERVICE DIPLOMA CREATE: SUCCESS - Created diploma with ID: %d\n", id)
+	return createdDiploma, nil
+}
+
+// validateDiploma holds the content rules shared by creating, updating and
+// importing diplomas.
+func validateDiploma(diploma domain.Diploma) error {
+	if diploma.Title == "" {
+		return errors.New("Title invalid")
+	}
+	if len(diploma.Description) > 500 {
+		return errors.New("Description too long")
+	}
+	return nil
+}
+
+// UpdateResource lets students change only their own diplomas, admins may
+// change any. A non-empty ifMatch holds the versions the client last saw; the
+// update fails with ErrVersionMismatch once the diploma has moved on.
+func (d *Diplomas) UpdateResource(id int64, diploma domain.Diploma, ifMatch []int64, actor domain.Actor) (domain.Diploma, error) {
+	return d.renovate(id, diploma, ifMatch, actor, domain.RevisionUpdate)
+}
+
+// renovate writes new content to a diploma and records it as a revision of
+// the given action.
+func (d *Diplomas) renovate(id int64, diploma domain.Diploma, ifMatch []int64, actor domain.Actor, action string) (domain.Diploma, error) {
+	if id == 0 {
+		return domain.Diploma{}, errors.New("id invalid")
+	}
+	if err := validateDiploma(diploma); err != nil {
+		return domain.Diploma{}, err
+	}
+
+	existing, err := d.storage.SelectResource(id)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+	if actor.Role != domain.RoleAdmin && existing.OwnerID != actor.ID {
+		return domain.Diploma{}, domain.ErrForbidden
+	}
+	if !matchesVersion(existing.Version, ifMatch) {
+		return domain.Diploma{}, domain.ErrVersionMismatch
+	}
+	if existing.Status != domain.DiplomaStatusDraft {
+		return domain.Diploma{}, domain.ErrDiplomaLocked
+	}
+
+	fmt.Printf("DEBUG SERVICE DIPLOMA UPDATE: Calling storage.RenovationResource\n")
+	renovated, err := d.storage.RenovationResource(id, diploma, ifMatch, actor.ID, action)
+	if err != nil {
+		fmt.Printf("DEBUG SERVICE DIPLOMA UPDATE: Storage error: %v\n", err)
+		return domain.Diploma{}, err
+	}
+
+	updatedDiploma := existing
+	updatedDiploma.Title = renovated.Title
+	updatedDiploma.Description = renovated.Description
+	updatedDiploma.Version = renovated.Version
+	updatedDiploma.UpdatedAt = renovated.UpdatedAt
+
+	fmt.Printf("DEBUG SERVICE DIPLOMA UPDATE: SUCCESS - Updated diploma with ID: %d\n", id)
+	return updatedDiploma, nil
+}
+
+// DeleteResource moves the diploma to the trash, from where an admin can
+// restore it until the purge job removes it for good.
+func (d *Diplomas) DeleteResource(id int64, ifMatch []int64, actor domain.Actor) error {
+	err := d.storage.DestroyResource(id, ifMatch, actor.ID)
 	if err != nil {
 		return err
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func (d *Diplomas) RestoreResource(id int64, actor domain.Actor) (domain.Diploma, error) {
+	if err := d.storage.RestoreResource(id, actor.ID); err != nil {
+		return domain.Diploma{}, err
+	}
+
+	return d.storage.SelectResource(id)
+}
+
+// Transition applies a lifecycle action to a diploma. The action must be
+// allowed from the current status and granted to the actor's role; submit is
+// additionally reserved to the owner.
+func (d *Diplomas) Transition(id int64, action string, actor domain.Actor, reason string) (domain.Diploma, error) {
+	transition, ok := domain.DiplomaTransitions[action]
+	if !ok {
+		return domain.Diploma{}, fmt.Errorf("unknown action %q", action)
+	}
+
+	if !domain.Can(actor.Role, transition.Permission) {
+		return domain.Diploma{}, domain.ErrForbidden
+	}
+
+	reason = strings.TrimSpace(reason)
+	if transition.NeedsReason && reason == "" {
+		return domain.Diploma{}, domain.ErrReasonRequired
+	}
+
+	diploma, err := d.storage.SelectResource(id)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+
+	if transition.OwnerOnly && actor.Role != domain.RoleAdmin && diploma.OwnerID != actor.ID {
+		return domain.Diploma{}, domain.ErrForbidden
+	}
+
+	if actor.Role == domain.RoleSupervisor && (diploma.SupervisorID == nil || *diploma.SupervisorID != actor.ID) {
+		return domain.Diploma{}, domain.ErrForbidden
+	}
+
+	if !transition.AllowedFrom(diploma.Status) {
+		return domain.Diploma{}, domain.ErrInvalidTransition
+	}
+
+	err = d.storage.TransitionResource(id, diploma.Status, transition.To, actor.ID, reason)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+
+	return d.storage.SelectResource(id)
+}
+
+func (d *Diplomas) GetStatusHistory(id int64) ([]domain.DiplomaStatusChange, error) {
+	if _, err := d.storage.SelectResource(id); err != nil {
+		return nil, err
+	}
+	return d.storage.SelectStatusHistory(id)
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"errors"
+	"gosmol/internal/domain"
+	"testing"
+)
+
+// fakeDiplomasStorage keeps diplomas in memory. Methods a test does not need
+// fall through to the embedded nil interface and panic.
+type fakeDiplomasStorage struct {
+	DiplomasStorage
+	diplomas map[int64]domain.Diploma
+	// concurrentEdit, when set, changes the stored diploma once right before
+	// the next write, as if another request had saved first.
+	concurrentEdit func(*domain.Diploma)
+}
+
+func (s *fakeDiplomasStorage) SelectResource(id int64) (domain.Diploma, error) {
+	diploma, ok := s.diplomas[id]
+	if !ok {
+		return domain.Diploma{}, domain.ErrDiplomaNotFound
+	}
+	return diploma, nil
+}
+
+func (s *fakeDiplomasStorage) TransitionResource(id int64, from string, to string, actorID int64, reason string) error {
+	diploma := s.diplomas[id]
+	if diploma.Status != from {
+		return domain.ErrInvalidTransition
+	}
+	diploma.Status = to
+	diploma.Version++
+	s.diplomas[id] = diploma
+	return nil
+}
+
+func TestTransition(t *testing.T) {
+	supervisorID := int64(20)
+	student := domain.Actor{ID: 10, Role: domain.RoleStudent}
+	otherStudent := domain.Actor{ID: 11, Role: domain.RoleStudent}
+	supervisor := domain.Actor{ID: supervisorID, Role: domain.RoleSupervisor}
+	otherSupervisor := domain.Actor{ID: 21, Role: domain.RoleSupervisor}
+	admin := domain.Actor{ID: 1, Role: domain.RoleAdmin}
+
+	tests := []struct {
+		name       string
+		status     string
+		supervised bool
+		action     string
+		actor      domain.Actor
+		reason     string
+		want       string
+		wantErr    error
+	}{
+		{"owner submits a draft", domain.DiplomaStatusDraft, false, domain.DiplomaActionSubmit, student, "", domain.DiplomaStatusSubmitted, nil},
+		{"admin submits for the owner", domain.DiplomaStatusDraft, false, domain.DiplomaActionSubmit, admin, "", domain.DiplomaStatusSubmitted, nil},
+		{"another student submits", domain.DiplomaStatusDraft, false, domain.DiplomaActionSubmit, otherStudent, "", "", domain.ErrForbidden},
+		{"submit twice", domain.DiplomaStatusSubmitted, false, domain.DiplomaActionSubmit, student, "", "", domain.ErrInvalidTransition},
+		{"supervisor takes into review", domain.DiplomaStatusSubmitted, true, domain.DiplomaActionReview, supervisor, "", domain.DiplomaStatusUnderReview, nil},
+		{"unassigned supervisor", domain.DiplomaStatusSubmitted, true, domain.DiplomaActionReview, otherSupervisor, "", "", domain.ErrForbidden},
+		{"supervisor of an unsupervised diploma", domain.DiplomaStatusSubmitted, false, domain.DiplomaActionReview, supervisor, "", "", domain.ErrForbidden},
+		{"student approves", domain.DiplomaStatusUnderReview, false, domain.DiplomaActionApprove, student, "", "", domain.ErrForbidden},
+		{"approve a draft", domain.DiplomaStatusDraft, false, domain.DiplomaActionApprove, admin, "", "", domain.ErrInvalidTransition},
+		{"approve after review", domain.DiplomaStatusUnderReview, false, domain.DiplomaActionApprove, admin, "", domain.DiplomaStatusApproved, nil},
+		{"reject without reason", domain.DiplomaStatusSubmitted, false, domain.DiplomaActionReject, admin, "  ", "", domain.ErrReasonRequired},
+		{"reject a submitted diploma", domain.DiplomaStatusSubmitted, false, domain.DiplomaActionReject, admin, "too short", domain.DiplomaStatusDraft, nil},
+		{"reject under review", domain.DiplomaStatusUnderReview, true, domain.DiplomaActionReject, supervisor, "too short", domain.DiplomaStatusDraft, nil},
+		{"reject an approved diploma", domain.DiplomaStatusApproved, false, domain.DiplomaActionReject, admin, "late", "", domain.ErrInvalidTransition},
+		{"defend before approval", domain.DiplomaStatusUnderReview, false, domain.DiplomaActionDefend, admin, "", "", domain.ErrInvalidTransition},
+		{"defend an approved diploma", domain.DiplomaStatusApproved, false, domain.DiplomaActionDefend, admin, "", domain.DiplomaStatusDefended, nil},
+		{"defended is final", domain.DiplomaStatusDefended, false, domain.DiplomaActionReject, admin, "again", "", domain.ErrInvalidTransition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diploma := domain.Diploma{ID: 1, OwnerID: student.ID, Status: tt.status, Version: 1}
+			if tt.supervised {
+				diploma.SupervisorID = &supervisorID
+			}
+			storage := &fakeDiplomasStorage{diplomas: map[int64]domain.Diploma{1: diploma}}
+
+			got, err := NewDiplomas(storage, nil).Transition(1, tt.action, tt.actor, tt.reason)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Transition error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if storage.diplomas[1].Status != tt.status {
+					t.Errorf("refused transition changed status to %q", storage.diplomas[1].Status)
+				}
+				return
+			}
+			if got.Status != tt.want {
+				t.Errorf("status = %q, want %q", got.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransitionUnknown(t *testing.T) {
+	storage := &fakeDiplomasStorage{diplomas: map[int64]domain.Diploma{
+		1: {ID: 1, OwnerID: 10, Status: domain.DiplomaStatusDraft},
+	}}
+	admin := domain.Actor{ID: 1, Role: domain.RoleAdmin}
+
+	if _, err := NewDiplomas(storage, nil).Transition(1, "archive", admin, ""); err == nil {
+		t.Error("expected an error for an unknown action")
+	}
+	if _, err := NewDiplomas(storage, nil).Transition(2, domain.DiplomaActionApprove, admin, ""); !errors.Is(err, domain.ErrDiplomaNotFound) {
+		t.Errorf("Transition error = %v, want ErrDiplomaNotFound", err)
+	}
+}
+
+// Every status except defended must have a way forward, and only the actions
+// listed in the table may leave it.
+func TestDiplomaTransitionsTable(t *testing.T) {
+	statuses := []string{
+		domain.DiplomaStatusDraft, domain.DiplomaStatusSubmitted, domain.DiplomaStatusUnderReview,
+		domain.DiplomaStatusApproved, domain.DiplomaStatusDefended,
+	}
+	allowed := map[string][]string{
+		domain.DiplomaStatusDraft:       {domain.DiplomaActionSubmit},
+		domain.DiplomaStatusSubmitted:   {domain.DiplomaActionReview, domain.DiplomaActionReject},
+		domain.DiplomaStatusUnderReview: {domain.DiplomaActionApprove, domain.DiplomaActionReject},
+		domain.DiplomaStatusApproved:    {domain.DiplomaActionDefend},
+		domain.DiplomaStatusDefended:    nil,
+	}
+
+	for _, status := range statuses {
+		for action, transition := range domain.DiplomaTransitions {
+			want := false
+			for _, a := range allowed[status] {
+				want = want || a == action
+			}
+			if got := transition.AllowedFrom(status); got != want {
+				t.Errorf("%s from %s allowed = %t, want %t", action, status, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gosmol/internal/domain"
+	"gosmol/pkg/filestorage"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+type FilesStorage interface {
+	InsertFile(file domain.DiplomaFile) (int64, error)
+	SelectFiles(diplomaID int64) ([]domain.DiplomaFile, error)
+	SelectFile(diplomaID int64, fileID int64) (domain.DiplomaFile, error)
+}
+
+// DiplomaReader is the part of the diploma storage the files service needs
+// to check access.
+type DiplomaReader interface {
+	SelectResource(id int64) (domain.Diploma, error)
+}
+
+type FilesOptions struct {
+	// MaxSize is the upload limit in bytes.
+	MaxSize int64
+	// AllowedTypes are the MIME types accepted, matched against the type
+	// sniffed from the content rather than the one the client sent.
+	AllowedTypes []string
+}
+
+type Files struct {
+	storage  FilesStorage
+	diplomas DiplomaReader
+	blobs    filestorage.Storage
+	options  FilesOptions
+}
+
+func NewFiles(storage FilesStorage, diplomas DiplomaReader, blobs filestorage.Storage, options FilesOptions) *Files {
+	return &Files{storage: storage, diplomas: diplomas, blobs: blobs, options: options}
+}
+
+func (f *Files) MaxSize() int64 {
+	return f.options.MaxSize
+}
+
+// Upload stores a file for the diploma. Like the diploma itself, files can
+// only be added by the owner while it is a draft, or by an admin. The content
+// is hashed and size-checked while it streams to the backend; an oversized
+// upload is removed again.
+func (f *Files) Upload(diplomaID int64, filename string, r io.Reader, actor domain.Actor) (domain.DiplomaFile, error) {
+	diploma, err := f.diplomas.SelectResource(diplomaID)
+	if err != nil {
+		return domain.DiplomaFile{}, err
+	}
+	if actor.Role != domain.RoleAdmin {
+		if diploma.OwnerID != actor.ID {
+			return domain.DiplomaFile{}, domain.ErrForbidden
+		}
+		if diploma.Status != domain.DiplomaStatusDraft {
+			return domain.DiplomaFile{}, domain.ErrDiplomaLocked
+		}
+	}
+
+	filename, err = cleanFilename(filename)
+	if err != nil {
+		return domain.DiplomaFile{}, err
+	}
+
+	buffered := bufio.NewReaderSize(r, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return domain.DiplomaFile{}, err
+	}
+	if len(head) == 0 {
+		return domain.DiplomaFile{}, domain.ErrFileEmpty
+	}
+
+	contentType := http.DetectContentType(head)
+	if !f.allowed(contentType) {
+		return domain.DiplomaFile{}, fmt.Errorf("%w: %s", domain.ErrFileTypeDenied, contentType)
+	}
+
+	key, err := fileKey(diplomaID)
+	if err != nil {
+		return domain.DiplomaFile{}, err
+	}
+
+	hash := sha256.New()
+	counter := &countingReader{r: io.LimitReader(buffered, f.options.MaxSize+1)}
+	ctx := context.Background()
+	if err := f.blobs.Put(ctx, key, io.TeeReader(counter, hash), -1, contentType); err != nil {
+		return domain.DiplomaFile{}, err
+	}
+
+	if counter.n > f.options.MaxSize {
+		f.blobs.Delete(ctx, key)
+		return domain.DiplomaFile{}, domain.ErrFileTooLarge
+	}
+
+	file := domain.DiplomaFile{
+		DiplomaID:   diplomaID,
+		StorageKey:  key,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        counter.n,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		UploadedBy:  actor.ID,
+	}
+
+	file.ID, err = f.storage.InsertFile(file)
+	if err != nil {
+		f.blobs.Delete(ctx, key)
+		return domain.DiplomaFile{}, err
+	}
+
+	fmt.Printf("DEBUG FILES: Stored %s (%d bytes, %s) for diploma %d\n", file.Filename, file.Size, file.ContentType, diplomaID)
+	return f.storage.SelectFile(diplomaID, file.ID)
+}
+
+func (f *Files) List(diplomaID int64) ([]domain.DiplomaFile, error) {
+	if _, err := f.diplomas.SelectResource(diplomaID); err != nil {
+		return nil, err
+	}
+	return f.storage.SelectFiles(diplomaID)
+}
+
+// Open returns the file metadata and a seekable reader over its content. The
+// caller closes the reader. Files of a diploma in the trash are not served.
+func (f *Files) Open(diplomaID int64, fileID int64) (domain.DiplomaFile, io.ReadSeekCloser, error) {
+	if _, err := f.diplomas.SelectResource(diplomaID); err != nil {
+		return domain.DiplomaFile{}, nil, err
+	}
+
+	file, err := f.storage.SelectFile(diplomaID, fileID)
+	if err != nil {
+		return domain.DiplomaFile{}, nil, err
+	}
+
+	content, _, err := f.blobs.Open(context.Background(), file.StorageKey)
+	if errors.Is(err, filestorage.ErrNotFound) {
+		return domain.DiplomaFile{}, nil, domain.ErrFileNotFound
+	}
+	if err != nil {
+		return domain.DiplomaFile{}, nil, err
+	}
+
+	return file, content, nil
+}
+
+func (f *Files) allowed(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	for _, allowed := range f.options.AllowedTypes {
+		if strings.EqualFold(strings.TrimSpace(allowed), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanFilename keeps only the base name the client sent, for display and
+// Content-Disposition. The stored object uses a random key instead.
+func cleanFilename(name string) (string, error) {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == "/" {
+		return "", errors.New("filename is required")
+	}
+	if !utf8.ValidString(name) || len(name) > 255 {
+		return "", errors.New("filename invalid")
+	}
+	return name, nil
+}
+
+func fileKey(diplomaID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("diplomas/%d/%s", diplomaID, hex.EncodeToString(b)), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
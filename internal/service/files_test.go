@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"gosmol/internal/domain"
+	"gosmol/pkg/filestorage"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fakeFilesStorage struct {
+	files []domain.DiplomaFile
+}
+
+func (s *fakeFilesStorage) InsertFile(file domain.DiplomaFile) (int64, error) {
+	file.ID = int64(len(s.files) + 1)
+	s.files = append(s.files, file)
+	return file.ID, nil
+}
+
+func (s *fakeFilesStorage) SelectFiles(diplomaID int64) ([]domain.DiplomaFile, error) {
+	var files []domain.DiplomaFile
+	for _, file := range s.files {
+		if file.DiplomaID == diplomaID {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (s *fakeFilesStorage) SelectFile(diplomaID int64, fileID int64) (domain.DiplomaFile, error) {
+	for _, file := range s.files {
+		if file.DiplomaID == diplomaID && file.ID == fileID {
+			return file, nil
+		}
+	}
+	return domain.DiplomaFile{}, domain.ErrFileNotFound
+}
+
+type fakeDiplomaReader map[int64]domain.Diploma
+
+func (r fakeDiplomaReader) SelectResource(id int64) (domain.Diploma, error) {
+	diploma, ok := r[id]
+	if !ok {
+		return domain.Diploma{}, domain.ErrDiplomaNotFound
+	}
+	return diploma, nil
+}
+
+const pdfHeader = "%PDF-1.4\n"
+
+func newTestFiles(t *testing.T) (*Files, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	blobs, err := filestorage.NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diplomas := fakeDiplomaReader{
+		1: {ID: 1, OwnerID: 10, Status: domain.DiplomaStatusDraft},
+		2: {ID: 2, OwnerID: 10, Status: domain.DiplomaStatusSubmitted},
+	}
+	files := NewFiles(&fakeFilesStorage{}, diplomas, blobs, FilesOptions{
+		MaxSize:      64,
+		AllowedTypes: []string{"application/pdf"},
+	})
+	return files, root
+}
+
+func storedBlobs(t *testing.T, root string) []string {
+	t.Helper()
+
+	var blobs []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			blobs = append(blobs, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs
+}
+
+func TestFilesUploadRejects(t *testing.T) {
+	owner := domain.Actor{ID: 10, Role: domain.RoleStudent}
+
+	tests := []struct {
+		name      string
+		diplomaID int64
+		filename  string
+		content   string
+		actor     domain.Actor
+		wantErr   error
+	}{
+		{"too large", 1, "thesis.pdf", pdfHeader + strings.Repeat("x", 64), owner, domain.ErrFileTooLarge},
+		{"type not allowed", 1, "thesis.pdf", "plain text pretending to be a pdf", owner, domain.ErrFileTypeDenied},
+		{"empty", 1, "thesis.pdf", "", owner, domain.ErrFileEmpty},
+		{"not the owner", 1, "thesis.pdf", pdfHeader, domain.Actor{ID: 11, Role: domain.RoleStudent}, domain.ErrForbidden},
+		{"not a draft", 2, "thesis.pdf", pdfHeader, owner, domain.ErrDiplomaLocked},
+		{"unknown diploma", 3, "thesis.pdf", pdfHeader, owner, domain.ErrDiplomaNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, root := newTestFiles(t)
+
+			_, err := files.Upload(tt.diplomaID, tt.filename, strings.NewReader(tt.content), tt.actor)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Upload error = %v, want %v", err, tt.wantErr)
+			}
+			if blobs := storedBlobs(t, root); len(blobs) != 0 {
+				t.Errorf("rejected upload left blobs behind: %v", blobs)
+			}
+		})
+	}
+}
+
+func TestFilesUpload(t *testing.T) {
+	files, _ := newTestFiles(t)
+	content := pdfHeader + "thesis"
+
+	tests := []struct {
+		name  string
+		actor domain.Actor
+	}{
+		{"owner", domain.Actor{ID: 10, Role: domain.RoleStudent}},
+		{"admin on a submitted diploma", domain.Actor{ID: 1, Role: domain.RoleAdmin}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diplomaID := int64(i + 1)
+			file, err := files.Upload(diplomaID, `C:\Users\ivan\thesis.pdf`, strings.NewReader(content), tt.actor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if file.Filename != "thesis.pdf" {
+				t.Errorf("Filename = %q", file.Filename)
+			}
+			if file.ContentType != "application/pdf" || file.Size != int64(len(content)) || file.UploadedBy != tt.actor.ID {
+				t.Errorf("file = %+v", file)
+			}
+
+			meta, r, err := files.Open(diplomaID, file.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, []byte(content)) || meta.SHA256 != file.SHA256 {
+				t.Errorf("stored content = %q, sha256 %s", got, meta.SHA256)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"gosmol/internal/domain"
+	"strings"
+)
+
+type ImportStorage interface {
+	SelectImportUsers(emails []string) ([]domain.ImportUser, error)
+	InsertResources(diplomas []domain.Diploma, commit bool) ([]int64, error)
+}
+
+// Importer loads diploma topics in bulk. Every row is checked before anything
+// is written, and the rows are inserted in one transaction, so a file is
+// either imported completely or not at all.
+type Importer struct {
+	storage ImportStorage
+}
+
+func NewImporter(storage ImportStorage) *Importer {
+	return &Importer{storage: storage}
+}
+
+// ImportResources validates rows with the rules of CreateResource and
+// resolves the student and supervisor emails. If any row is invalid the
+// report lists every problem and ErrImportRejected is returned. With dryRun
+// the inserts run and are rolled back, so database errors still surface.
+func (i *Importer) ImportResources(rows []domain.ImportRow, dryRun bool) (domain.ImportReport, error) {
+	report := domain.ImportReport{Rows: len(rows), DryRun: dryRun}
+
+	users, err := i.lookupUsers(rows)
+	if err != nil {
+		return report, err
+	}
+
+	diplomas := make([]domain.Diploma, 0, len(rows))
+	load := make(map[int64]int)
+	for _, row := range rows {
+		rowErrors := len(report.Errors)
+		fail := func(field, message string) {
+			report.Errors = append(report.Errors, domain.ImportError{Line: row.Line, Field: field, Message: message})
+		}
+
+		diploma := domain.Diploma{Title: row.Title, Description: row.Description}
+		if err := validateDiploma(diploma); err != nil {
+			fail("", err.Error())
+		}
+
+		student, ok := users[normalizeEmail(row.StudentEmail)]
+		switch {
+		case row.StudentEmail == "":
+			fail("student_email", "is required")
+		case !ok:
+			fail("student_email", "no user with this email")
+		case student.Role != domain.RoleStudent:
+			fail("student_email", "user is not a student")
+		default:
+			diploma.OwnerID = student.ID
+		}
+
+		if row.SupervisorEmail != "" {
+			supervisor, ok := users[normalizeEmail(row.SupervisorEmail)]
+			switch {
+			case !ok:
+				fail("supervisor", "no user with this email")
+			case supervisor.Role != domain.RoleSupervisor:
+				fail("supervisor", "user is not a supervisor")
+			case supervisor.Assigned+load[supervisor.ID] >= supervisor.Capacity:
+				fail("supervisor", domain.ErrCapacityReached.Error())
+			default:
+				load[supervisor.ID]++
+				diploma.SupervisorID = &supervisor.ID
+			}
+		}
+
+		if len(report.Errors) == rowErrors {
+			diplomas = append(diplomas, diploma)
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		return report, domain.ErrImportRejected
+	}
+
+	ids, err := i.storage.InsertResources(diplomas, !dryRun)
+	if err != nil {
+		return report, err
+	}
+
+	report.Imported = len(ids)
+	if !dryRun {
+		report.IDs = ids
+	}
+
+	fmt.Printf("DEBUG SERVICE IMPORT: %d of %d rows imported, dry run %t\n", report.Imported, report.Rows, dryRun)
+	return report, nil
+}
+
+func (i *Importer) lookupUsers(rows []domain.ImportRow) (map[string]domain.ImportUser, error) {
+	seen := make(map[string]bool)
+	var emails []string
+	for _, row := range rows {
+		for _, email := range []string{row.StudentEmail, row.SupervisorEmail} {
+			email = normalizeEmail(email)
+			if email != "" && !seen[email] {
+				seen[email] = true
+				emails = append(emails, email)
+			}
+		}
+	}
+
+	users := make(map[string]domain.ImportUser)
+	if len(emails) == 0 {
+		return users, nil
+	}
+
+	found, err := i.storage.SelectImportUsers(emails)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range found {
+		users[normalizeEmail(user.Email)] = user
+	}
+	return users, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"gosmol/internal/domain"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeImportStorage struct {
+	users    []domain.ImportUser
+	inserted []domain.Diploma
+	commit   bool
+}
+
+func (s *fakeImportStorage) SelectImportUsers(emails []string) ([]domain.ImportUser, error) {
+	var found []domain.ImportUser
+	for _, user := range s.users {
+		for _, email := range emails {
+			if strings.EqualFold(user.Email, email) {
+				found = append(found, user)
+			}
+		}
+	}
+	return found, nil
+}
+
+func (s *fakeImportStorage) InsertResources(diplomas []domain.Diploma, commit bool) ([]int64, error) {
+	s.inserted, s.commit = diplomas, commit
+	ids := make([]int64, len(diplomas))
+	for i := range ids {
+		ids[i] = int64(100 + i)
+	}
+	return ids, nil
+}
+
+func newTestImportStorage() *fakeImportStorage {
+	return &fakeImportStorage{users: []domain.ImportUser{
+		{ID: 10, Email: "ivan@example.com", Role: domain.RoleStudent},
+		{ID: 11, Email: "petr@example.com", Role: domain.RoleStudent},
+		{ID: 20, Email: "anna@example.com", Role: domain.RoleSupervisor, Capacity: 2, Assigned: 1},
+		{ID: 1, Email: "admin@example.com", Role: domain.RoleAdmin},
+	}}
+}
+
+func TestImportResourcesRejects(t *testing.T) {
+	valid := domain.ImportRow{Line: 2, Title: "Topic", StudentEmail: "ivan@example.com"}
+
+	tests := []struct {
+		name string
+		rows []domain.ImportRow
+		want []domain.ImportError
+	}{
+		{
+			"missing title",
+			[]domain.ImportRow{{Line: 2, StudentEmail: "ivan@example.com"}},
+			[]domain.ImportError{{Line: 2, Message: "Title invalid"}},
+		},
+		{
+			"description too long",
+			[]domain.ImportRow{{Line: 2, Title: "Topic", Description: strings.Repeat("a", 501), StudentEmail: "ivan@example.com"}},
+			[]domain.ImportError{{Line: 2, Message: "Description too long"}},
+		},
+		{
+			"missing student",
+			[]domain.ImportRow{{Line: 2, Title: "Topic"}},
+			[]domain.ImportError{{Line: 2, Field: "student_email", Message: "is required"}},
+		},
+		{
+			"unknown student",
+			[]domain.ImportRow{{Line: 2, Title: "Topic", StudentEmail: "nobody@example.com"}},
+			[]domain.ImportError{{Line: 2, Field: "student_email", Message: "no user with this email"}},
+		},
+		{
+			"student is an admin",
+			[]domain.ImportRow{{Line: 2, Title: "Topic", StudentEmail: "admin@example.com"}},
+			[]domain.ImportError{{Line: 2, Field: "student_email", Message: "user is not a student"}},
+		},
+		{
+			"supervisor is a student",
+			[]domain.ImportRow{{Line: 2, Title: "Topic", StudentEmail: "ivan@example.com", SupervisorEmail: "petr@example.com"}},
+			[]domain.ImportError{{Line: 2, Field: "supervisor", Message: "user is not a supervisor"}},
+		},
+		{
+			"unknown supervisor",
+			[]domain.ImportRow{{Line: 2, Title: "Topic", StudentEmail: "ivan@example.com", SupervisorEmail: "nobody@example.com"}},
+			[]domain.ImportError{{Line: 2, Field: "supervisor", Message: "no user with this email"}},
+		},
+		{
+			"supervisor capacity counts earlier rows",
+			[]domain.ImportRow{
+				{Line: 2, Title: "One", StudentEmail: "ivan@example.com", SupervisorEmail: "anna@example.com"},
+				{Line: 3, Title: "Two", StudentEmail: "petr@example.com", SupervisorEmail: "ANNA@example.com"},
+			},
+			[]domain.ImportError{{Line: 3, Field: "supervisor", Message: domain.ErrCapacityReached.Error()}},
+		},
+		{
+			"every problem is reported",
+			[]domain.ImportRow{
+				valid,
+				{Line: 3, StudentEmail: "nobody@example.com"},
+			},
+			[]domain.ImportError{
+				{Line: 3, Message: "Title invalid"},
+				{Line: 3, Field: "student_email", Message: "no user with this email"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestImportStorage()
+
+			report, err := NewImporter(storage).ImportResources(tt.rows, false)
+			if !errors.Is(err, domain.ErrImportRejected) {
+				t.Fatalf("ImportResources error = %v, want ErrImportRejected", err)
+			}
+			if !reflect.DeepEqual(report.Errors, tt.want) {
+				t.Errorf("errors = %+v, want %+v", report.Errors, tt.want)
+			}
+			if storage.inserted != nil || report.Imported != 0 {
+				t.Errorf("rejected import wrote %d diplomas", len(storage.inserted))
+			}
+		})
+	}
+}
+
+func TestImportResources(t *testing.T) {
+	rows := []domain.ImportRow{
+		{Line: 2, Title: "One", Description: "About", StudentEmail: " Ivan@Example.com ", SupervisorEmail: "anna@example.com"},
+		{Line: 3, Title: "Two", StudentEmail: "petr@example.com"},
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		storage := newTestImportStorage()
+
+		report, err := NewImporter(storage).ImportResources(rows, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Rows != 2 || report.Imported != 2 || report.DryRun != dryRun {
+			t.Errorf("dry run %t: report = %+v", dryRun, report)
+		}
+		if storage.commit == dryRun {
+			t.Errorf("dry run %t: commit = %t", dryRun, storage.commit)
+		}
+		if dryRun != (report.IDs == nil) {
+			t.Errorf("dry run %t: ids = %v", dryRun, report.IDs)
+		}
+
+		if len(storage.inserted) != 2 {
+			t.Fatalf("inserted %d diplomas", len(storage.inserted))
+		}
+		first := storage.inserted[0]
+		if first.OwnerID != 10 || first.SupervisorID == nil || *first.SupervisorID != 20 || first.Title != "One" {
+			t.Errorf("first diploma = %+v", first)
+		}
+		if storage.inserted[1].OwnerID != 11 || storage.inserted[1].SupervisorID != nil {
+			t.Errorf("second diploma = %+v", storage.inserted[1])
+		}
+	}
+}
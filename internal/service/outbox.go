@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"gosmol/internal/domain"
+	"gosmol/pkg/email"
+	"math/rand"
+	"time"
+)
+
+type OutboxStorage interface {
+	ClaimOutbox(limit int, lease time.Duration) ([]domain.OutboxMessage, error)
+	MarkOutboxSent(id int64) error
+	MarkOutboxFailed(id int64, attempts int, nextAttemptAt time.Time, lastError string, dead bool) error
+	SelectOutbox(status string, limits int64, page int64) ([]domain.OutboxMessage, error)
+	RetryOutbox(id int64) error
+}
+
+type OutboxOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+}
+
+type Outbox struct {
+	storage OutboxStorage
+	sender  email.Sender
+	options OutboxOptions
+}
+
+func NewOutbox(storage OutboxStorage, sender email.Sender, options OutboxOptions) *Outbox {
+	return &Outbox{storage: storage, sender: sender, options: options}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.DispatchOnce(); err != nil {
+			fmt.Printf("DEBUG OUTBOX: dispatch failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchOnce delivers one batch of due messages and reports how many were sent.
+func (o *Outbox) DispatchOnce() (int, error) {
+	lease := o.options.PollInterval * 10
+	messages, err := o.storage.ClaimOutbox(o.options.BatchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, msg := range messages {
+		deliverErr := o.deliver(msg)
+		if deliverErr == nil {
+			if err := o.storage.MarkOutboxSent(msg.ID); err != nil {
+				return sent, err
+			}
+			sent++
+			continue
+		}
+
+		attempts := msg.Attempts + 1
+		dead := attempts >= msg.MaxAttempts
+		nextAttemptAt := time.Now().Add(o.backoff(attempts))
+		if dead {
+			fmt.Printf("DEBUG OUTBOX: message %d dead-lettered after %d attempts: %v\n", msg.ID, attempts, deliverErr)
+		}
+
+		err := o.storage.MarkOutboxFailed(msg.ID, attempts, nextAttemptAt, deliverErr.Error(), dead)
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+func (o *Outbox) deliver(msg domain.OutboxMessage) error {
+	switch msg.Kind {
+	case domain.OutboxKindEmail:
+		return o.sender.Send(email.Message{
+			To:      msg.Recipient,
+			Subject: msg.Subject,
+			Text:    msg.BodyText,
+			HTML:    msg.BodyHTML,
+		})
+	default:
+		return fmt.Errorf("unknown outbox message kind %q", msg.Kind)
+	}
+}
+
+// backoff grows exponentially with the attempt number and adds up to 20%
+// jitter so failed messages do not retry in lockstep.
+func (o *Outbox) backoff(attempt int) time.Duration {
+	delay := o.options.BaseBackoff
+	for i := 1; i < attempt && delay < o.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > o.options.MaxBackoff {
+		delay = o.options.MaxBackoff
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+func (o *Outbox) GetMessages(status string, limits int64, page int64) ([]domain.OutboxMessage, error) {
+	switch status {
+	case "", domain.OutboxStatusPending, domain.OutboxStatusSent, domain.OutboxStatusDead:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", domain.ErrInvalidOutboxQuery, status)
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	return o.storage.SelectOutbox(status, limits, page)
+}
+
+func (o *Outbox) RetryMessage(id int64) error {
+	if id == 0 {
+		return fmt.Errorf("%w: id invalid", domain.ErrInvalidOutboxQuery)
+	}
+
+	return o.storage.RetryOutbox(id)
+}
+
+// emailNotification converts a rendered email into an outbox row.
+func emailNotification(msg email.Message) domain.OutboxMessage {
+	return domain.OutboxMessage{
+		Kind:      domain.OutboxKindEmail,
+		Recipient: msg.To,
+		Subject:   msg.Subject,
+		BodyText:  msg.Text,
+		BodyHTML:  msg.HTML,
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gosmol/internal/domain"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// patchRetries bounds how often a patch is reapplied when the diploma
+// changes between reading it and writing the result.
+const patchRetries = 3
+
+// patchableDiploma is the document a patch is applied to. Only these fields
+// can be changed; a patch touching anything else is rejected.
+type patchableDiploma struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// PatchResource applies a merge patch (RFC 7396) or JSON patch (RFC 6902) to
+// the editable fields of a diploma and saves the result through the same
+// checks as UpdateResource. Without ifMatch the patch is pinned to the version
+// it was applied to and reapplied if someone else saved in between.
+func (d *Diplomas) PatchResource(id int64, format string, patch []byte, ifMatch []int64, actor domain.Actor) (domain.Diploma, error) {
+	if format != domain.MergePatch && format != domain.JSONPatch {
+		return domain.Diploma{}, domain.ErrUnsupportedPatch
+	}
+
+	for attempt := 1; ; attempt++ {
+		existing, err := d.storage.SelectResource(id)
+		if err != nil {
+			return domain.Diploma{}, err
+		}
+
+		patched, err := applyPatch(existing, format, patch)
+		if err != nil {
+			return domain.Diploma{}, err
+		}
+
+		expected := ifMatch
+		if len(expected) == 0 {
+			expected = []int64{existing.Version}
+		}
+
+		updated, err := d.renovate(id, patched, expected, actor, domain.RevisionUpdate)
+		if errors.Is(err, domain.ErrVersionMismatch) && len(ifMatch) == 0 && attempt < patchRetries {
+			continue
+		}
+		return updated, err
+	}
+}
+
+func applyPatch(diploma domain.Diploma, format string, patch []byte) (domain.Diploma, error) {
+	original, err := json.Marshal(patchableDiploma{Title: diploma.Title, Description: diploma.Description})
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+
+	var result []byte
+	switch format {
+	case domain.MergePatch:
+		result, err = jsonpatch.MergePatch(original, patch)
+	case domain.JSONPatch:
+		var operations jsonpatch.Patch
+		operations, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			result, err = operations.Apply(original)
+		}
+	}
+	if err != nil {
+		return domain.Diploma{}, fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+	}
+
+	// A removed field comes back absent or null and reads as empty; fields
+	// outside patchableDiploma, such as status, are refused.
+	var doc patchableDiploma
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return domain.Diploma{}, fmt.Errorf("%w: %v", domain.ErrInvalidPatch, err)
+	}
+
+	return domain.Diploma{Title: doc.Title, Description: doc.Description}, nil
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"gosmol/internal/domain"
+	"testing"
+)
+
+func (s *fakeDiplomasStorage) RenovationResource(id int64, diploma domain.Diploma, ifMatch []int64, actorID int64, action string) (domain.Diploma, error) {
+	existing := s.diplomas[id]
+	if s.concurrentEdit != nil {
+		s.concurrentEdit(&existing)
+		s.concurrentEdit = nil
+		existing.Version++
+		s.diplomas[id] = existing
+	}
+	if !matchesVersion(existing.Version, ifMatch) {
+		return domain.Diploma{}, domain.ErrVersionMismatch
+	}
+
+	existing.Title = diploma.Title
+	existing.Description = diploma.Description
+	existing.Version++
+	s.diplomas[id] = existing
+	return existing, nil
+}
+
+func TestApplyPatch(t *testing.T) {
+	diploma := domain.Diploma{
+		ID: 1, Title: "Old title", Description: "Old description",
+		Status: domain.DiplomaStatusDraft, OwnerID: 10, Version: 3,
+	}
+
+	tests := []struct {
+		name        string
+		format      string
+		patch       string
+		title       string
+		description string
+		wantErr     error
+	}{
+		{"merge title", domain.MergePatch, `{"title": "New title"}`, "New title", "Old description", nil},
+		{"merge both", domain.MergePatch, `{"title": "T", "description": "D"}`, "T", "D", nil},
+		{"merge null clears", domain.MergePatch, `{"description": null}`, "Old title", "", nil},
+		{"merge empty object", domain.MergePatch, `{}`, "Old title", "Old description", nil},
+		{"merge status", domain.MergePatch, `{"status": "approved"}`, "", "", domain.ErrInvalidPatch},
+		{"merge owner", domain.MergePatch, `{"owner_id": 11}`, "", "", domain.ErrInvalidPatch},
+		{"merge version", domain.MergePatch, `{"title": "T", "version": 9}`, "", "", domain.ErrInvalidPatch},
+		{"merge wrong type", domain.MergePatch, `{"title": 5}`, "", "", domain.ErrInvalidPatch},
+		{"merge malformed", domain.MergePatch, `{"title":`, "", "", domain.ErrInvalidPatch},
+		{"json replace", domain.JSONPatch, `[{"op": "replace", "path": "/title", "value": "New title"}]`, "New title", "Old description", nil},
+		{"json remove", domain.JSONPatch, `[{"op": "remove", "path": "/description"}]`, "Old title", "", nil},
+		{"json test passes", domain.JSONPatch, `[{"op": "test", "path": "/title", "value": "Old title"}, {"op": "replace", "path": "/title", "value": "T"}]`, "T", "Old description", nil},
+		{"json test fails", domain.JSONPatch, `[{"op": "test", "path": "/title", "value": "Other"}]`, "", "", domain.ErrInvalidPatch},
+		{"json add status", domain.JSONPatch, `[{"op": "add", "path": "/status", "value": "approved"}]`, "", "", domain.ErrInvalidPatch},
+		{"json add owner", domain.JSONPatch, `[{"op": "add", "path": "/owner_id", "value": 11}]`, "", "", domain.ErrInvalidPatch},
+		{"json missing path", domain.JSONPatch, `[{"op": "replace", "path": "/supervisor_id", "value": 2}]`, "", "", domain.ErrInvalidPatch},
+		{"json not a list", domain.JSONPatch, `{"op": "replace"}`, "", "", domain.ErrInvalidPatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyPatch(diploma, tt.format, []byte(tt.patch))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("applyPatch error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got.Title != tt.title || got.Description != tt.description {
+				t.Errorf("patched = %q / %q, want %q / %q", got.Title, got.Description, tt.title, tt.description)
+			}
+			if got.Status != "" || got.OwnerID != 0 || got.Version != 0 {
+				t.Errorf("patch result carries more than the editable fields: %+v", got)
+			}
+		})
+	}
+}
+
+func TestPatchResource(t *testing.T) {
+	owner := domain.Actor{ID: 10, Role: domain.RoleStudent}
+	draft := domain.Diploma{ID: 1, Title: "Old title", Description: "Old", Status: domain.DiplomaStatusDraft, OwnerID: 10, Version: 3}
+
+	tests := []struct {
+		name    string
+		format  string
+		patch   string
+		ifMatch []int64
+		actor   domain.Actor
+		status  string
+		wantErr error
+	}{
+		{"unsupported format", "application/json", `{"title": "T"}`, nil, owner, domain.DiplomaStatusDraft, domain.ErrUnsupportedPatch},
+		{"other student", domain.MergePatch, `{"title": "T"}`, nil, domain.Actor{ID: 11, Role: domain.RoleStudent}, domain.DiplomaStatusDraft, domain.ErrForbidden},
+		{"submitted diploma", domain.MergePatch, `{"title": "T"}`, nil, owner, domain.DiplomaStatusSubmitted, domain.ErrDiplomaLocked},
+		{"stale If-Match", domain.MergePatch, `{"title": "T"}`, []int64{2}, owner, domain.DiplomaStatusDraft, domain.ErrVersionMismatch},
+		{"owner edits draft", domain.MergePatch, `{"title": "T"}`, []int64{3}, owner, domain.DiplomaStatusDraft, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diploma := draft
+			diploma.Status = tt.status
+			storage := &fakeDiplomasStorage{diplomas: map[int64]domain.Diploma{1: diploma}}
+
+			got, err := NewDiplomas(storage, nil).PatchResource(1, tt.format, []byte(tt.patch), tt.ifMatch, tt.actor)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PatchResource error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (got.Title != "T" || got.Version != 4) {
+				t.Errorf("patched diploma = %+v", got)
+			}
+		})
+	}
+}
+
+// A patched diploma goes through the same validation as a full update.
+func TestPatchResourceValidates(t *testing.T) {
+	storage := &fakeDiplomasStorage{diplomas: map[int64]domain.Diploma{
+		1: {ID: 1, Title: "Old title", Status: domain.DiplomaStatusDraft, OwnerID: 10, Version: 3},
+	}}
+
+	_, err := NewDiplomas(storage, nil).PatchResource(1, domain.MergePatch, []byte(`{"title": ""}`), nil, domain.Actor{ID: 10, Role: domain.RoleStudent})
+	if err == nil {
+		t.Fatal("expected an empty title to be refused")
+	}
+	if storage.diplomas[1].Title != "Old title" {
+		t.Errorf("refused patch was saved: %+v", storage.diplomas[1])
+	}
+}
+
+// Without If-Match a patch that loses a race is applied again on top of the
+// concurrent change instead of overwriting it.
+func TestPatchResourceRetriesConcurrentEdit(t *testing.T) {
+	storage := &fakeDiplomasStorage{diplomas: map[int64]domain.Diploma{
+		1: {ID: 1, Title: "Old title", Description: "Old", Status: domain.DiplomaStatusDraft, OwnerID: 10, Version: 3},
+	}}
+	storage.concurrentEdit = func(d *domain.Diploma) { d.Description = "Edited meanwhile" }
+
+	got, err := NewDiplomas(storage, nil).PatchResource(1, domain.MergePatch, []byte(`{"title": "T"}`), nil, domain.Actor{ID: 10, Role: domain.RoleStudent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "T" || got.Description != "Edited meanwhile" || got.Version != 5 {
+		t.Errorf("patched diploma = %+v", got)
+	}
+}
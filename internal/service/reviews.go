@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gosmol/internal/domain"
+	"math"
+	"strings"
+)
+
+// SubmitReview creates or replaces the actor's review of a diploma. Reviews
+// are accepted while the diploma is under review or approved and until an
+// admin finalizes the grading.
+func (d *Diplomas) SubmitReview(diplomaID int64, input domain.ReviewInput, actor domain.Actor) (domain.Review, error) {
+	if !domain.Can(actor.Role, domain.PermDiplomaReview) {
+		return domain.Review{}, domain.ErrForbidden
+	}
+
+	diploma, err := d.storage.SelectResource(diplomaID)
+	if err != nil {
+		return domain.Review{}, err
+	}
+	if diploma.OwnerID == actor.ID {
+		return domain.Review{}, domain.ErrForbidden
+	}
+	if diploma.Status != domain.DiplomaStatusUnderReview && diploma.Status != domain.DiplomaStatusApproved {
+		return domain.Review{}, domain.ErrNotReviewable
+	}
+
+	scores, weighted, err := scoreReview(input)
+	if err != nil {
+		return domain.Review{}, err
+	}
+
+	review := domain.Review{
+		DiplomaID:     diplomaID,
+		ReviewerID:    actor.ID,
+		Grade:         input.Grade,
+		Comment:       strings.TrimSpace(input.Comment),
+		Scores:        scores,
+		WeightedScore: weighted,
+	}
+
+	id, err := d.storage.UpsertReview(review)
+	if err != nil {
+		return domain.Review{}, err
+	}
+
+	reviews, err := d.storage.SelectReviews(diplomaID)
+	if err != nil {
+		return domain.Review{}, err
+	}
+	for _, r := range reviews {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+
+	return domain.Review{}, errors.New("saved review not found")
+}
+
+// scoreReview checks that every rubric criterion is scored exactly once on
+// the grade scale and returns the scores in rubric order with their weighted
+// mean.
+func scoreReview(input domain.ReviewInput) ([]domain.CriterionScore, float64, error) {
+	if input.Grade < domain.GradeMin || input.Grade > domain.GradeMax {
+		return nil, 0, fmt.Errorf("grade must be between %d and %d", domain.GradeMin, domain.GradeMax)
+	}
+	if len(input.Comment) > 10000 {
+		return nil, 0, errors.New("comment too long")
+	}
+
+	known := make(map[string]bool, len(domain.Rubric))
+	for _, criterion := range domain.Rubric {
+		known[criterion.Key] = true
+	}
+
+	byCriterion := make(map[string]domain.CriterionScore, len(input.Scores))
+	for _, score := range input.Scores {
+		if !known[score.Criterion] {
+			return nil, 0, fmt.Errorf("unknown criterion %q", score.Criterion)
+		}
+		if _, dup := byCriterion[score.Criterion]; dup {
+			return nil, 0, fmt.Errorf("criterion %q is scored twice", score.Criterion)
+		}
+		byCriterion[score.Criterion] = score
+	}
+
+	scores := make([]domain.CriterionScore, 0, len(domain.Rubric))
+	var weighted float64
+	for _, criterion := range domain.Rubric {
+		score, ok := byCriterion[criterion.Key]
+		if !ok {
+			return nil, 0, fmt.Errorf("criterion %q is not scored", criterion.Key)
+		}
+		if score.Score < domain.GradeMin || score.Score > domain.GradeMax {
+			return nil, 0, fmt.Errorf("score of %q must be between %d and %d", criterion.Key, domain.GradeMin, domain.GradeMax)
+		}
+		score.Comment = strings.TrimSpace(score.Comment)
+		scores = append(scores, score)
+		weighted += criterion.Weight * float64(score.Score)
+	}
+
+	return scores, math.Round(weighted*100) / 100, nil
+}
+
+// GetReviews returns the reviews of a diploma with the mean grade and mean
+// weighted score across reviewers.
+func (d *Diplomas) GetReviews(diplomaID int64) (domain.ReviewSummary, error) {
+	grade, finalizedAt, err := d.storage.SelectGrading(diplomaID)
+	if err != nil {
+		return domain.ReviewSummary{}, err
+	}
+
+	reviews, err := d.storage.SelectReviews(diplomaID)
+	if err != nil {
+		return domain.ReviewSummary{}, err
+	}
+
+	summary := domain.ReviewSummary{
+		Rubric:      domain.Rubric,
+		Reviews:     reviews,
+		FinalGrade:  grade,
+		FinalizedAt: finalizedAt,
+	}
+
+	if len(reviews) > 0 {
+		var grades, weighted float64
+		for _, r := range reviews {
+			grades += float64(r.Grade)
+			weighted += r.WeightedScore
+		}
+		averageGrade := math.Round(grades/float64(len(reviews))*100) / 100
+		weightedScore := math.Round(weighted/float64(len(reviews))*100) / 100
+		summary.AverageGrade = &averageGrade
+		summary.WeightedScore = &weightedScore
+	}
+
+	return summary, nil
+}
+
+// FinalizeGrading fixes the final grade from the reviews and locks them.
+func (d *Diplomas) FinalizeGrading(diplomaID int64, actor domain.Actor) (domain.ReviewSummary, error) {
+	if !domain.Can(actor.Role, domain.PermGradeFinalize) {
+		return domain.ReviewSummary{}, domain.ErrForbidden
+	}
+
+	if _, err := d.storage.FinalizeGrading(diplomaID, actor.ID); err != nil {
+		return domain.ReviewSummary{}, err
+	}
+
+	return d.GetReviews(diplomaID)
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"gosmol/internal/domain"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func scores(relevance, methodology, results, presentation int) []domain.CriterionScore {
+	return []domain.CriterionScore{
+		{Criterion: "relevance", Score: relevance},
+		{Criterion: "methodology", Score: methodology},
+		{Criterion: "results", Score: results},
+		{Criterion: "presentation", Score: presentation},
+	}
+}
+
+func TestScoreReview(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    domain.ReviewInput
+		weighted float64
+		wantErr  string
+	}{
+		{"all top marks", domain.ReviewInput{Grade: 5, Scores: scores(5, 5, 5, 5)}, 5, ""},
+		{"all lowest marks", domain.ReviewInput{Grade: 2, Scores: scores(2, 2, 2, 2)}, 2, ""},
+		{"weighted mean", domain.ReviewInput{Grade: 4, Scores: scores(5, 4, 3, 5)}, 4.05, ""},
+		{"mixed scores", domain.ReviewInput{Grade: 4, Scores: scores(2, 3, 4, 5)}, 3.55, ""},
+		{"grade below scale", domain.ReviewInput{Grade: 1, Scores: scores(5, 5, 5, 5)}, 0, "grade must be between"},
+		{"grade above scale", domain.ReviewInput{Grade: 6, Scores: scores(5, 5, 5, 5)}, 0, "grade must be between"},
+		{"score below scale", domain.ReviewInput{Grade: 4, Scores: scores(5, 1, 5, 5)}, 0, `score of "methodology"`},
+		{"score above scale", domain.ReviewInput{Grade: 4, Scores: scores(5, 5, 6, 5)}, 0, `score of "results"`},
+		{"zero score", domain.ReviewInput{Grade: 4, Scores: scores(0, 5, 5, 5)}, 0, `score of "relevance"`},
+		{"missing criterion", domain.ReviewInput{Grade: 4, Scores: scores(5, 5, 5, 5)[:3]}, 0, `"presentation" is not scored`},
+		{"unknown criterion", domain.ReviewInput{Grade: 4, Scores: append(scores(5, 5, 5, 5), domain.CriterionScore{Criterion: "style", Score: 5})}, 0, `unknown criterion "style"`},
+		{"criterion scored twice", domain.ReviewInput{Grade: 4, Scores: append(scores(5, 5, 5, 5), domain.CriterionScore{Criterion: "results", Score: 4})}, 0, `"results" is scored twice`},
+		{"no scores", domain.ReviewInput{Grade: 4}, 0, "is not scored"},
+		{"comment too long", domain.ReviewInput{Grade: 4, Comment: strings.Repeat("a", 10001), Scores: scores(5, 5, 5, 5)}, 0, "comment too long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, weighted, err := scoreReview(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("scoreReview error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if weighted != tt.weighted {
+				t.Errorf("weighted = %v, want %v", weighted, tt.weighted)
+			}
+			if len(got) != len(domain.Rubric) {
+				t.Fatalf("got %d scores, want %d", len(got), len(domain.Rubric))
+			}
+		})
+	}
+}
+
+// Scores come back in rubric order with trimmed comments, whatever order the
+// reviewer sent them in.
+func TestScoreReviewOrdersByRubric(t *testing.T) {
+	input := domain.ReviewInput{Grade: 5, Scores: []domain.CriterionScore{
+		{Criterion: "presentation", Score: 5},
+		{Criterion: "results", Score: 4, Comment: "  solid  "},
+		{Criterion: "relevance", Score: 3},
+		{Criterion: "methodology", Score: 5},
+	}}
+
+	got, _, err := scoreReview(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []domain.CriterionScore{
+		{Criterion: "relevance", Score: 3},
+		{Criterion: "methodology", Score: 5},
+		{Criterion: "results", Score: 4, Comment: "solid"},
+		{Criterion: "presentation", Score: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scores = %+v, want %+v", got, want)
+	}
+}
+
+func TestRubricWeightsAddUp(t *testing.T) {
+	var total float64
+	for _, criterion := range domain.Rubric {
+		total += criterion.Weight
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Errorf("rubric weights add up to %v", total)
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"gosmol/internal/domain"
+	"gosmol/pkg/diff"
+)
+
+func (d *Diplomas) GetRevisions(id int64) ([]domain.DiplomaRevision, error) {
+	if _, err := d.storage.SelectResource(id); err != nil {
+		return nil, err
+	}
+	return d.storage.SelectRevisions(id)
+}
+
+func (d *Diplomas) GetRevision(id int64, version int64) (domain.DiplomaRevision, error) {
+	if _, err := d.storage.SelectResource(id); err != nil {
+		return domain.DiplomaRevision{}, err
+	}
+	return d.storage.SelectRevision(id, version)
+}
+
+// DiffRevisions compares two revisions of a diploma word by word. A zero to
+// compares against the latest revision.
+func (d *Diplomas) DiffRevisions(id int64, from int64, to int64) (domain.DiplomaDiff, error) {
+	if from < 1 || to < 0 {
+		return domain.DiplomaDiff{}, domain.ErrRevisionNotFound
+	}
+
+	older, err := d.GetRevision(id, from)
+	if err != nil {
+		return domain.DiplomaDiff{}, err
+	}
+	newer, err := d.storage.SelectRevision(id, to)
+	if err != nil {
+		return domain.DiplomaDiff{}, err
+	}
+
+	return domain.DiplomaDiff{
+		DiplomaID:   id,
+		From:        older.Version,
+		To:          newer.Version,
+		Title:       diffChunks(older.Title, newer.Title),
+		Description: diffChunks(older.Description, newer.Description),
+	}, nil
+}
+
+// RevertResource writes the content of an earlier revision back as a new
+// revision. It is an edit like any other: only the owner or an admin may do
+// it, only while the diploma is a draft, and ifMatch applies.
+func (d *Diplomas) RevertResource(id int64, version int64, ifMatch []int64, actor domain.Actor) (domain.Diploma, error) {
+	if version < 1 {
+		return domain.Diploma{}, domain.ErrRevisionNotFound
+	}
+
+	revision, err := d.GetRevision(id, version)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+
+	content := domain.Diploma{Title: revision.Title, Description: revision.Description}
+	return d.renovate(id, content, ifMatch, actor, domain.RevisionRevert)
+}
+
+func diffChunks(a, b string) []domain.DiffChunk {
+	chunks := []domain.DiffChunk{}
+	for _, c := range diff.Words(a, b) {
+		chunks = append(chunks, domain.DiffChunk{Op: c.Op, Text: c.Text})
+	}
+	return chunks
+}
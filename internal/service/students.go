@@ -5,263 +5,350 @@ import (
 	"errors"
 	"fmt"
 	"gosmol/internal/domain"
+	"gosmol/pkg/auth"
+	"gosmol/pkg/email"
 	"math"
 	"math/big"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type StudentsStorage interface {
-	InsertStudents(students domain.Student) (int64, error)
+	InsertStudentWithVerification(student domain.Student, tokenHash string, expiresAt time.Time, notification domain.OutboxMessage) (int64, error)
 	SelectStudents(email string) (domain.Student, error)
 	SelectStudentsByID(userID int64) (domain.Student, error)
-	RefreshStore(userID int64, token string, expiresAt time.Time) error
-	RefreshGet(token string) (int64, error)
-	RefreshDelete(token string) error
+	RefreshStore(userID int64, tokenHash string, sessionID string, client domain.ClientInfo, expiresAt time.Time) error
+	RefreshRotate(oldHash string, newHash string, client domain.ClientInfo, expiresAt time.Time) (int64, string, error)
+	SelectSessions(userID int64) ([]domain.Session, error)
+	RevokeSession(userID int64, sessionID string) error
+	RevokeAllSessions(userID int64) error
 	StudentBlocked(email string, windowStart time.Time) ([]map[string]interface{}, error)
 	LogAttempt(email string, result bool, attemptTime time.Time) error
 	GetFailedLogAttempts(email string, windowStart time.Time) (int, error)
 	BlockStudent(email, blockedUntil string) error
 	RenovationTwoFAStatus(userID int64, enabled bool) error
+	RenovationTwoFAMethod(userID int64, method string) error
+	RenovationRole(userID int64, role string) error
+	InsertPasswordReset(userID int64, tokenHash string, expiresAt time.Time, notification domain.OutboxMessage) error
+	SelectRecentPasswordResets(userID int64, since time.Time) (int, error)
+	ResetPassword(tokenHash string, passwordHash string) (int64, error)
+	InsertEmailVerification(userID int64, tokenHash string, expiresAt time.Time, notification domain.OutboxMessage) error
+	SelectRecentEmailVerifications(userID int64, since time.Time) (int, error)
+	VerifyEmail(tokenHash string) (int64, error)
 }
 
 type TwoFaStorage interface {
-	InsertTwoFaCode(userID int64, code string, expiresAt time.Time) error
+	InsertTwoFaCode(userID int64, code string, expiresAt time.Time, notification domain.OutboxMessage) error
 	SelectTwoFaCodeByUserID(userID int64) (domain.TwoFaCode, error)
 	RenovationTwoFaCodeAttempts(codeID int64, attempts int) error
 	MarkTwoFaCodeUsed(codeID int64) error
 	SelectRecentCodeRequests(userID int64, since time.Time) (int, error)
 	SelectRecentVerificationAttempts(userID int64, since time.Time) (int, error)
+	UpsertTotpSecret(userID int64, secret string) error
+	SelectTotpSecret(userID int64) (domain.TotpSecret, error)
+	ConfirmTotpSecret(userID int64, step int64) error
+	RenovationTotpAttempts(userID int64, attempts int) error
+	MarkTotpStepUsed(userID int64, step int64) error
+	DestroyTotpSecret(userID int64) error
+	ReplaceRecoveryCodes(userID int64, hashes []string) error
+	UseRecoveryCode(userID int64, hash string) (bool, error)
+	CountRecoveryCodes(userID int64) (int, error)
+}
+
+const (
+	totpIssuer       = "Gosmol Diplomas"
+	twoFaCodeTTL     = 5 * time.Minute
+	passwordResetTTL = 30 * time.Minute
+	emailVerifyTTL   = 48 * time.Hour
+)
+
+type StudentsOptions struct {
+	// PublicURL is the address of the frontend used to build links in emails.
+	PublicURL string
+	// RequireEmailVerification makes login refuse accounts that have not
+	// confirmed their email address yet.
+	RequireEmailVerification bool
+	// Issuer and Audience go into the iss/aud claims of access tokens.
+	// Temp and refresh tokens are only ever consumed by this service, so
+	// their audience is the issuer itself.
+	Issuer   string
+	Audience string
 }
 
 type Students struct {
 	storage      StudentsStorage
 	twoFaStorage TwoFaStorage
-	jwtSecret    string
+	keys         *auth.KeySet
+	options      StudentsOptions
 }
 
-func NewStudents(storage StudentsStorage, twoFa TwoFaStorage, jwt string) *Students{
-	return &Students{storage: storage, twoFaStorage: twoFa, jwtSecret: jwt}
+func NewStudents(storage StudentsStorage, twoFa TwoFaStorage, keys *auth.KeySet, options StudentsOptions) *Students {
+	return &Students{storage: storage, twoFaStorage: twoFa, keys: keys, options: options}
 }
 
 func (s *Students) StudentsRegister(student domain.Student) (domain.Student, error) {
-    fmt.Printf("DEBUG SERVICE REGISTER: Starting registration for: %s\n", student.Email)
-    
-    if student.Firstname == "" || student.Lastname == "" || student.Email == "" {
-        return domain.Student{}, errors.New("Invalid input: all fields are required")
-    }
-
-    if student.Password == "" || len(student.Password) < 8 {
-        return domain.Student{}, errors.New("Invalid password input: password must be at least 8 characters")
-    }
-
-    hasLetters, _ := regexp.MatchString(`[a-zA-Zа-яА-Я]`, student.Password)
-    hasDigits, _ := regexp.MatchString(`[0-9]`, student.Password)
-    hasSpecial, _ := regexp.MatchString(`[^a-zA-Zа-яА-Я0-9\s]`, student.Password)
-
-    if !hasLetters || !hasDigits || !hasSpecial {
-        return domain.Student{}, errors.New("Invalid password input: password must contain letters, digits and special characters")
-    }
-
-    hash, err := bcrypt.GenerateFromPassword([]byte(student.Password), bcrypt.DefaultCost)
-    if err != nil {
-        return domain.Student{}, errors.New("Error hashing password")
-    }
-
-    studentToSave := domain.Student{
-        Firstname:    student.Firstname,
-        Lastname:     student.Lastname, 
-        Email:        student.Email,
-        PasswordHash: string(hash),
-    }
-
-    fmt.Printf("DEBUG SERVICE REGISTER: Calling storage.InsertStudents\n")
-    id, err := s.storage.InsertStudents(studentToSave)
-    if err != nil {
-        fmt.Printf("DEBUG SERVICE REGISTER: Storage error: %v\n", err)
-        return domain.Student{}, err
-    }
-    
-    createdStudent := domain.Student{
-        ID:        id,
-        Firstname: student.Firstname,
-        Lastname:  student.Lastname,
-        Email:     student.Email,
-        CreatedAt: time.Now(),
-    }
-    
-    fmt.Printf("DEBUG SERVICE REGISTER: SUCCESS - Created student with ID: %d\n", id)
-    return createdStudent, nil
-}
-
-func (s *Students) StudentsLogin(student domain.Student) (domain.TokenResponse, domain.TwoFaCodes, error) {
-    fmt.Printf("DEBUG LOGIN: Attempting login for email: '%s'\n", student.Email)
-    fmt.Printf("DEBUG LOGIN: Password provided: '%s'\n", student.Password)
-    fmt.Printf("DEBUG LOGIN: TwoFA enabled: '%v'\n", student.TwoFAEnabled)
-    
-    if student.Email == "" || student.Password == "" {
-        fmt.Printf("DEBUG LOGIN: Email or password empty\n")
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("email and password are required")
-    }
-    
-    blocked, minutesLeft, err := s.IsUserBlocked(student.Email)
-    if err != nil {
-        fmt.Printf("DEBUG LOGIN: Error checking block status: %v\n", err)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, err
-    }
-    
-    if blocked {
-        fmt.Printf("DEBUG LOGIN: User is blocked for %d minutes\n", minutesLeft)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, fmt.Errorf("your account is blocked for %d minutes", minutesLeft)
-    }
-    
-    fmt.Printf("DEBUG LOGIN: Searching user in database...\n")
-    dbStudent, err := s.storage.SelectStudents(student.Email)
-    if err != nil {
-        fmt.Printf("DEBUG LOGIN: Database error or user not found: %v\n", err)
-        s.LogLoginAttempt(student.Email, false)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("invalid credentials")
-    }
-    
-    fmt.Printf("DEBUG LOGIN: User found - ID: %d, Email: %s\n", dbStudent.ID, dbStudent.Email)
-    fmt.Printf("DEBUG LOGIN: Stored password hash: %s\n", dbStudent.PasswordHash)
-    fmt.Printf("DEBUG LOGIN: Provided password: %s\n", student.Password)
-    
-    fmt.Printf("DEBUG LOGIN: Comparing passwords...\n")
-    err = bcrypt.CompareHashAndPassword([]byte(dbStudent.PasswordHash), []byte(student.Password))
-    if err != nil {
-        fmt.Printf("DEBUG LOGIN: Password comparison failed: %v\n", err)
-        s.LogLoginAttempt(student.Email, false)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("invalid credentials")
-    }
-    
-    fmt.Printf("DEBUG LOGIN: Password correct!\n")
-    
-    attempts, err := s.GetFailedAttempts(student.Email)
-    if err != nil {
-        fmt.Printf("DEBUG LOGIN: Error getting failed attempts: %v\n", err)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, err
-    }
-    
-    maxAttempts := int64(5)
-    if attempts >= maxAttempts {
-        fmt.Printf("DEBUG LOGIN: Too many failed attempts: %d\n", attempts)
-        s.BlockUser(student.Email)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("too many failed attempts, account blocked")
-    }
-
-    if dbStudent.TwoFAEnabled != false {
-        tempToken, err := s.GenerateTempToken(dbStudent.ID)
-        if err != nil {
-            fmt.Printf("DEBUG LOGIN: Error generating temp token: %v\n", err)
-            return domain.TokenResponse{}, domain.TwoFaCodes{}, err
-        }
-        return domain.TokenResponse{}, domain.TwoFaCodes{RequiresTwoFa: true, TempToken: tempToken}, nil
-    }
-    
-    accessToken, err := s.GenerateAccessToken(dbStudent.ID)
-    if err != nil {
-        fmt.Printf("DEBUG LOGIN: Error generating access token: %v\n", err)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, err
-    }
-    
-    refreshToken, err := s.GenerateRefreshToken(dbStudent.ID)
-    if err != nil {
-        fmt.Printf("DEBUG LOGIN: Error generating refresh token: %v\n", err)
-        return domain.TokenResponse{}, domain.TwoFaCodes{}, err
-    }
-    
-    s.LogLoginAttempt(student.Email, true)
-    fmt.Printf("DEBUG LOGIN: Login successful for user ID: %d\n", dbStudent.ID)
-    return domain.TokenResponse{AccessToken: accessToken, RefreshToken: refreshToken}, domain.TwoFaCodes{}, nil
-}
-
-func (s *Students) StudentsRefresh(refreshToken string) (domain.TokenResponse, error) {
-	studentID, err := s.storage.RefreshGet(refreshToken)
-	if err != nil {
-		return  domain.TokenResponse{}, errors.New("Invalid refresh token")
-	}
-
-	accessToken, err := s.GenerateAccessToken(studentID)
+	fmt.Printf("DEBUG SERVICE REGISTER: Starting registration for: %s\n", student.Email)
+
+	if student.Firstname == "" || student.Lastname == "" || student.Email == "" {
+		return domain.Student{}, errors.New("Invalid input: all fields are required")
+	}
+
+	if err := validatePassword(student.Password); err != nil {
+		return domain.Student{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(student.Password), bcrypt.DefaultCost)
 	if err != nil {
+		return domain.Student{}, errors.New("Error hashing password")
+	}
+
+	studentToSave := domain.Student{
+		Firstname:    student.Firstname,
+		Lastname:     student.Lastname,
+		Email:        student.Email,
+		PasswordHash: string(hash),
+	}
+
+	// The account and its verification email are stored together, so no
+	// account is left without a way to verify it.
+	tokenHash, expiresAt, notification, err := s.newEmailVerification(studentToSave)
+	if err != nil {
+		return domain.Student{}, err
+	}
+
+	fmt.Printf("DEBUG SERVICE REGISTER: Calling storage.InsertStudentWithVerification\n")
+	id, err := s.storage.InsertStudentWithVerification(studentToSave, tokenHash, expiresAt, notification)
+	if err != nil {
+		fmt.Printf("DEBUG SERVICE REGISTER: Storage error: %v\n", err)
+		return domain.Student{}, err
+	}
+
+	createdStudent := domain.Student{
+		ID:        id,
+		Firstname: student.Firstname,
+		Lastname:  student.Lastname,
+		Email:     student.Email,
+		CreatedAt: time.Now(),
+	}
+
+	fmt.Printf("DEBUG SERVICE REGISTER: SUCCESS - Created student with ID: %d\n", id)
+	return createdStudent, nil
+}
+
+func validatePassword(password string) error {
+	if password == "" || len(password) < 8 {
+		return errors.New("Invalid password input: password must be at least 8 characters")
+	}
+
+	hasLetters, _ := regexp.MatchString(`[a-zA-Zа-яА-Я]`, password)
+	hasDigits, _ := regexp.MatchString(`[0-9]`, password)
+	hasSpecial, _ := regexp.MatchString(`[^a-zA-Zа-яА-Я0-9\s]`, password)
+
+	if !hasLetters || !hasDigits || !hasSpecial {
+		return errors.New("Invalid password input: password must contain letters, digits and special characters")
+	}
+
+	return nil
+}
+
+func (s *Students) StudentsLogin(student domain.Student, client domain.ClientInfo) (domain.TokenResponse, domain.TwoFaCodes, error) {
+	fmt.Printf("DEBUG LOGIN: Attempting login for email: '%s'\n", student.Email)
+	fmt.Printf("DEBUG LOGIN: Password provided: '%s'\n", student.Password)
+	fmt.Printf("DEBUG LOGIN: TwoFA enabled: '%v'\n", student.TwoFAEnabled)
+
+	if student.Email == "" || student.Password == "" {
+		fmt.Printf("DEBUG LOGIN: Email or password empty\n")
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("email and password are required")
+	}
+
+	blocked, minutesLeft, err := s.IsUserBlocked(student.Email)
+	if err != nil {
+		fmt.Printf("DEBUG LOGIN: Error checking block status: %v\n", err)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, err
+	}
+
+	if blocked {
+		fmt.Printf("DEBUG LOGIN: User is blocked for %d minutes\n", minutesLeft)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, fmt.Errorf("your account is blocked for %d minutes", minutesLeft)
+	}
+
+	fmt.Printf("DEBUG LOGIN: Searching user in database...\n")
+	dbStudent, err := s.storage.SelectStudents(student.Email)
+	if err != nil {
+		fmt.Printf("DEBUG LOGIN: Database error or user not found: %v\n", err)
+		s.LogLoginAttempt(student.Email, false)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("invalid credentials")
+	}
+
+	fmt.Printf("DEBUG LOGIN: User found - ID: %d, Email: %s\n", dbStudent.ID, dbStudent.Email)
+	fmt.Printf("DEBUG LOGIN: Stored password hash: %s\n", dbStudent.PasswordHash)
+	fmt.Printf("DEBUG LOGIN: Provided password: %s\n", student.Password)
+
+	fmt.Printf("DEBUG LOGIN: Comparing passwords...\n")
+	err = bcrypt.CompareHashAndPassword([]byte(dbStudent.PasswordHash), []byte(student.Password))
+	if err != nil {
+		fmt.Printf("DEBUG LOGIN: Password comparison failed: %v\n", err)
+		s.LogLoginAttempt(student.Email, false)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("invalid credentials")
+	}
+
+	fmt.Printf("DEBUG LOGIN: Password correct!\n")
+
+	if s.options.RequireEmailVerification && !dbStudent.EmailVerified {
+		fmt.Printf("DEBUG LOGIN: Email not verified for user ID: %d\n", dbStudent.ID)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("email address is not verified")
+	}
+
+	attempts, err := s.GetFailedAttempts(student.Email)
+	if err != nil {
+		fmt.Printf("DEBUG LOGIN: Error getting failed attempts: %v\n", err)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, err
+	}
+
+	maxAttempts := int64(5)
+	if attempts >= maxAttempts {
+		fmt.Printf("DEBUG LOGIN: Too many failed attempts: %d\n", attempts)
+		s.BlockUser(student.Email)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, errors.New("too many failed attempts, account blocked")
+	}
+
+	if dbStudent.TwoFAEnabled != false {
+		tempToken, err := s.GenerateTempToken(dbStudent.ID)
+		if err != nil {
+			fmt.Printf("DEBUG LOGIN: Error generating temp token: %v\n", err)
+			return domain.TokenResponse{}, domain.TwoFaCodes{}, err
+		}
+		method := dbStudent.TwoFAMethod
+		if method == "" {
+			method = domain.TwoFaMethodEmail
+		}
+		return domain.TokenResponse{}, domain.TwoFaCodes{RequiresTwoFa: true, TempToken: tempToken, Method: method}, nil
+	}
+
+	tokens, err := s.startSession(dbStudent.ID, client)
+	if err != nil {
+		fmt.Printf("DEBUG LOGIN: Error starting session: %v\n", err)
+		return domain.TokenResponse{}, domain.TwoFaCodes{}, err
+	}
+
+	s.LogLoginAttempt(student.Email, true)
+	fmt.Printf("DEBUG LOGIN: Login successful for user ID: %d\n", dbStudent.ID)
+	return tokens, domain.TwoFaCodes{}, nil
+}
+
+func (s *Students) StudentsRefresh(refreshToken string, client domain.ClientInfo) (domain.TokenResponse, error) {
+	claims, err := s.keys.Verify(refreshToken, auth.TokenRefresh, s.options.Issuer, s.options.Issuer)
+	if err != nil {
+		return domain.TokenResponse{}, domain.ErrRefreshTokenInvalid
+	}
+
+	newClaims := s.refreshClaims(claims.UserID)
+	newRefreshToken, err := s.keys.Sign(newClaims)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	studentID, sessionID, err := s.storage.RefreshRotate(auth.HashToken(refreshToken), auth.HashToken(newRefreshToken), client, newClaims.ExpiresAt.Time)
+	if errors.Is(err, domain.ErrRefreshTokenReused) {
+		fmt.Printf("SECURITY: refresh token reuse for user ID %d, session %s revoked\n", studentID, sessionID)
 		return domain.TokenResponse{}, err
 	}
-	newRefreshToken, err := s.GenerateRefreshToken(studentID)
 	if err != nil {
 		return domain.TokenResponse{}, err
 	}
-	s.storage.RefreshDelete(refreshToken)
-	
+
+	accessToken, err := s.GenerateAccessToken(studentID, sessionID)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
 	return domain.TokenResponse{AccessToken: accessToken, RefreshToken: newRefreshToken}, nil
 }
 
-func (s *Students) GenerateAccessToken(id int64) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": id,
-		"exp":     time.Now().Add(15 * time.Minute).Unix(),
+// GenerateAccessToken reads the role from the database on every issue, so a
+// role change takes effect at the next refresh.
+func (s *Students) GenerateAccessToken(id int64, sessionID string) (string, error) {
+	student, err := s.storage.SelectStudentsByID(id)
+	if err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+
+	claims := auth.NewClaims(id, auth.TokenAccess, s.options.Issuer, s.options.Audience, 15*time.Minute)
+	claims.SessionID = sessionID
+	claims.Role = student.Role
+	return s.keys.Sign(claims)
 }
 
 func (s *Students) GenerateTempToken(id int64) (string, error) {
-	claims := jwt.MapClaims{
-		"user_id": id,
-		"exp":     time.Now().Add(10 * time.Minute).Unix(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	claims := auth.NewClaims(id, auth.TokenTemp, s.options.Issuer, s.options.Issuer, 10*time.Minute)
+	return s.keys.Sign(claims)
 }
 
-func (s *Students) GenerateRefreshToken(id int64) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = id
-	claims["exp"] = time.Now().Add(7 *24 * time.Hour).Unix()
-	
-	signed, err := token.SignedString([]byte(s.jwtSecret))
+// startSession opens a session for a completed login. The session id is the
+// refresh token family id and the sid claim of every access token issued in
+// it, so revoking the session cuts off both.
+func (s *Students) startSession(id int64, client domain.ClientInfo) (domain.TokenResponse, error) {
+	sessionID, err := auth.GenerateToken()
 	if err != nil {
-		return "", err
+		return domain.TokenResponse{}, err
+	}
+
+	claims := s.refreshClaims(id)
+	refreshToken, err := s.keys.Sign(claims)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	err = s.storage.RefreshStore(id, auth.HashToken(refreshToken), sessionID, client, claims.ExpiresAt.Time)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	accessToken, err := s.GenerateAccessToken(id, sessionID)
+	if err != nil {
+		return domain.TokenResponse{}, err
 	}
-	
-	expiresAt := time.Now().Add(7 * 24 * time.Hour)
-	err = s.storage.RefreshStore(id, signed, expiresAt)
-	return signed, err
+
+	return domain.TokenResponse{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+func (s *Students) refreshClaims(id int64) auth.Claims {
+	return auth.NewClaims(id, auth.TokenRefresh, s.options.Issuer, s.options.Issuer, 7*24*time.Hour)
 }
 
 func (s *Students) IsUserBlocked(email string) (bool, int64, error) {
 	now := time.Now().UTC()
 	windowStart := now
-	
+
 	result, err := s.storage.StudentBlocked(email, windowStart)
 	if err != nil {
 		fmt.Printf("Ошибка проверки блокировки: %v\n", err)
 		return false, 0, err
 	}
-	
+
 	if len(result) > 0 {
 		blockedUntilStr, ok := result[0]["blocked_until"].(string)
 		if !ok {
 			return false, 0, errors.New("invalid format for blocked_until")
 		}
-	
+
 		blockedUntil, err := time.Parse(time.RFC3339, blockedUntilStr)
 		if err != nil {
 			return false, 0, err
 		}
-	
+
 		minutesLeft := math.Ceil(time.Until(blockedUntil).Minutes())
 		if minutesLeft < 0 {
 			minutesLeft = 0
 		}
-	
+
 		return true, int64(minutesLeft), nil
 	}
-	
+
 	return false, 0, nil
 }
 
@@ -277,7 +364,7 @@ func (s *Students) LogLoginAttempt(email string, result bool) {
 func (s *Students) GetFailedAttempts(email string) (int64, error) {
 	now := time.Now().UTC()
 	windowStart := now.Add(-1 * time.Minute)
-	
+
 	count, err := s.storage.GetFailedLogAttempts(email, windowStart)
 	if err != nil {
 		fmt.Printf("Ошибка подсчета попыток: %v\n", err)
@@ -304,98 +391,189 @@ func (s *Students) StudentsSendEmailCode(tempToken string) error {
 	if err != nil {
 		return errors.New("Invalid temp token")
 	}
-	
+
 	fifteenMinutesAgo := time.Now().Add(-15 * time.Minute)
 	recentRequests, err := s.twoFaStorage.SelectRecentCodeRequests(userID, fifteenMinutesAgo)
 	if err != nil {
 		return err
 	}
-	
+
 	if recentRequests >= 3 {
 		return errors.New("too many code requests, please try again later")
 	}
-	
+
 	code, err := s.generateSixDigitCode()
 	if err != nil {
 		return errors.New("failed to generate code")
 	}
-	
-	expiresAt := time.Now().Add(5 * time.Minute)
-	err = s.twoFaStorage.InsertTwoFaCode(userID, code, expiresAt)
+
+	notification, err := s.codeEmail(userID, code)
 	if err != nil {
 		return err
 	}
-	
-	err = s.sendEmail(userID, code)
+
+	expiresAt := time.Now().Add(twoFaCodeTTL)
+	err = s.twoFaStorage.InsertTwoFaCode(userID, code, expiresAt, notification)
 	if err != nil {
 		return err
 	}
-	
+
 	return nil
 }
 
-func (s *Students) VerifyCode(code domain.Code) (domain.TokenResponse, error) {
+func (s *Students) VerifyCode(code domain.Code, client domain.ClientInfo) (domain.TokenResponse, error) {
 	userID, err := s.extractUserIDFromToken(code.TempToken)
 	if err != nil {
 		return domain.TokenResponse{}, errors.New("invalid temp token")
 	}
-	
+
+	method := code.Method
+	if method == "" {
+		student, err := s.storage.SelectStudentsByID(userID)
+		if err != nil {
+			return domain.TokenResponse{}, errors.New("user not found")
+		}
+		method = student.TwoFAMethod
+	}
+
+	var tokens domain.TokenResponse
+	switch method {
+	case domain.TwoFaMethodTotp:
+		err = s.verifyTotpCode(userID, code.Code)
+	case domain.TwoFaMethodEmail, "":
+		err = s.verifyEmailCode(userID, code.Code)
+	case domain.TwoFaMethodRecovery:
+		var remaining int
+		remaining, err = s.verifyRecoveryCode(userID, code.Code)
+		tokens.RecoveryCodesRemaining = &remaining
+		if remaining <= 3 {
+			tokens.Warning = fmt.Sprintf("only %d recovery codes left, please regenerate them", remaining)
+		}
+	default:
+		err = errors.New("unsupported two-factor method")
+	}
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	session, err := s.startSession(userID, client)
+	if err != nil {
+		return domain.TokenResponse{}, err
+	}
+
+	tokens.AccessToken = session.AccessToken
+	tokens.RefreshToken = session.RefreshToken
+	return tokens, nil
+}
+
+func (s *Students) verifyEmailCode(userID int64, code string) error {
 	tenMinuteAgo := time.Now().Add(-10 * time.Minute)
 	recentAttempts, err := s.twoFaStorage.SelectRecentVerificationAttempts(userID, tenMinuteAgo)
 	if err != nil {
-		return domain.TokenResponse{}, err
+		return err
 	}
-	
+
 	if recentAttempts >= 5 {
-		return domain.TokenResponse{}, errors.New("too many verification attempts, please try again later")
+		return errors.New("too many verification attempts, please try again later")
 	}
-	
+
 	twoFaCode, err := s.twoFaStorage.SelectTwoFaCodeByUserID(userID)
 	if err != nil {
-		return domain.TokenResponse{}, errors.New("invalid temp token or code not found")
+		return errors.New("invalid temp token or code not found")
 	}
-	
+
 	if twoFaCode.IsUsed {
-		return domain.TokenResponse{}, errors.New("code already used")
+		return errors.New("code already used")
 	}
-	
+
 	if twoFaCode.Attempts >= 3 {
-		return domain.TokenResponse{}, errors.New("too many attempts")
+		return errors.New("too many attempts")
 	}
-	
+
 	if time.Now().After(twoFaCode.ExpiresAt) {
-		return domain.TokenResponse{}, errors.New("code expires")
+		return errors.New("code expires")
 	}
-	
-	if twoFaCode.Code != code.Code {
+
+	if twoFaCode.Code != code {
 		err = s.twoFaStorage.RenovationTwoFaCodeAttempts(twoFaCode.ID, twoFaCode.Attempts+1)
 		if err != nil {
-			return domain.TokenResponse{}, err
+			return err
 		}
-		
+
 		remainingAttempts := 3 - (twoFaCode.Attempts + 1)
-		return domain.TokenResponse{}, fmt.Errorf("invalid code, %d attempts remaining", remainingAttempts)
+		return fmt.Errorf("invalid code, %d attempts remaining", remainingAttempts)
 	}
-	
-	err = s.twoFaStorage.MarkTwoFaCodeUsed(twoFaCode.ID)
+
+	return s.twoFaStorage.MarkTwoFaCodeUsed(twoFaCode.ID)
+}
+
+func (s *Students) verifyTotpCode(userID int64, code string) error {
+	totp, err := s.twoFaStorage.SelectTotpSecret(userID)
+	if err != nil || !totp.Confirmed {
+		return errors.New("authenticator app is not enrolled")
+	}
+
+	attempts := totp.Attempts
+	if time.Since(totp.LastAttemptAt) > 10*time.Minute {
+		attempts = 0
+	}
+
+	if attempts >= 5 {
+		return errors.New("too many verification attempts, please try again later")
+	}
+
+	step, ok := auth.ValidateTotp(totp.Secret, code, time.Now())
+	if !ok || step <= totp.LastUsedStep {
+		err = s.twoFaStorage.RenovationTotpAttempts(userID, attempts+1)
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("invalid code, %d attempts remaining", 5-(attempts+1))
+	}
+
+	return s.twoFaStorage.MarkTotpStepUsed(userID, step)
+}
+
+func (s *Students) verifyRecoveryCode(userID int64, code string) (int, error) {
+	student, err := s.storage.SelectStudentsByID(userID)
 	if err != nil {
-		return domain.TokenResponse{}, err
+		return 0, errors.New("user not found")
+	}
+
+	if !student.TwoFAEnabled {
+		return 0, errors.New("two-factor authentication is not enabled")
 	}
-	
-	accessToken, err := s.GenerateAccessToken(twoFaCode.UserID)
+
+	ok, err := s.twoFaStorage.UseRecoveryCode(userID, auth.HashRecoveryCode(code))
 	if err != nil {
-		return domain.TokenResponse{}, err
+		return 0, err
+	}
+
+	if !ok {
+		return 0, errors.New("invalid recovery code")
 	}
-	
-	refreshToken, err := s.GenerateRefreshToken(twoFaCode.UserID)
+
+	return s.twoFaStorage.CountRecoveryCodes(userID)
+}
+
+func (s *Students) issueRecoveryCodes(userID int64) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
 	if err != nil {
-		return domain.TokenResponse{}, err
+		return nil, errors.New("failed to generate recovery codes")
 	}
-	
-	return domain.TokenResponse{
-		AccessToken: accessToken,
-		RefreshToken: refreshToken,
-	}, nil
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	err = s.twoFaStorage.ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
 }
 
 func (s *Students) generateSixDigitCode() (string, error) {
@@ -407,13 +585,49 @@ func (s *Students) generateSixDigitCode() (string, error) {
 	return fmt.Sprintf("%06d", n.Int64()+100000), nil
 }
 
-func (s *Students) sendEmail(userID int64, code string) error {
-	fmt.Printf("Sending email to user %d: Your code: %s (valid for 5 minutes)\n", userID, code)
-	return nil
+func (s *Students) codeEmail(userID int64, code string) (domain.OutboxMessage, error) {
+	student, err := s.storage.SelectStudentsByID(userID)
+	if err != nil {
+		return domain.OutboxMessage{}, errors.New("user not found")
+	}
+
+	msg, err := email.Render(email.TemplateTwoFaCode, student.Email, map[string]interface{}{
+		"Name":       student.Firstname,
+		"Code":       code,
+		"TTLMinutes": int(twoFaCodeTTL.Minutes()),
+	})
+	if err != nil {
+		return domain.OutboxMessage{}, err
+	}
+
+	return emailNotification(msg), nil
 }
 
-func (s *Students) EnableTwoFA(userID int64) error {
-	return s.storage.RenovationTwoFAStatus(userID, true)
+func (s *Students) EnableTwoFA(userID int64) ([]string, error) {
+	err := s.storage.RenovationTwoFAStatus(userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.issueRecoveryCodes(userID)
+}
+
+func (s *Students) RegenerateRecoveryCodes(userID int64, password string) ([]string, error) {
+	student, err := s.storage.SelectStudentsByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(student.PasswordHash), []byte(password))
+	if err != nil {
+		return nil, errors.New("Invalid password")
+	}
+
+	if !student.TwoFAEnabled {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	return s.issueRecoveryCodes(userID)
 }
 
 func (s *Students) DisableTwoFA(userID int64, password string) error {
@@ -421,32 +635,303 @@ func (s *Students) DisableTwoFA(userID int64, password string) error {
 	if err != nil {
 		return errors.New("user not found")
 	}
-	
+
 	err = bcrypt.CompareHashAndPassword([]byte(student.PasswordHash), []byte(password))
 	if err != nil {
 		return errors.New("Invalid password")
 	}
-	
+
+	err = s.twoFaStorage.DestroyTotpSecret(userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.twoFaStorage.ReplaceRecoveryCodes(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.RenovationTwoFAMethod(userID, domain.TwoFaMethodEmail)
+	if err != nil {
+		return err
+	}
+
 	return s.storage.RenovationTwoFAStatus(userID, false)
 }
 
+func (s *Students) EnrollTotp(userID int64) (domain.TotpEnrollment, error) {
+	student, err := s.storage.SelectStudentsByID(userID)
+	if err != nil {
+		return domain.TotpEnrollment{}, errors.New("user not found")
+	}
+
+	existing, err := s.twoFaStorage.SelectTotpSecret(userID)
+	if err == nil && existing.Confirmed {
+		return domain.TotpEnrollment{}, errors.New("authenticator app already enrolled, disable 2FA first")
+	}
+
+	secret, err := auth.GenerateTotpSecret()
+	if err != nil {
+		return domain.TotpEnrollment{}, errors.New("failed to generate secret")
+	}
+
+	err = s.twoFaStorage.UpsertTotpSecret(userID, secret)
+	if err != nil {
+		return domain.TotpEnrollment{}, err
+	}
+
+	return domain.TotpEnrollment{
+		Secret: secret,
+		URI:    auth.TotpURI(totpIssuer, student.Email, secret),
+	}, nil
+}
+
+func (s *Students) TotpQRCode(userID int64) ([]byte, error) {
+	student, err := s.storage.SelectStudentsByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	totp, err := s.twoFaStorage.SelectTotpSecret(userID)
+	if err != nil {
+		return nil, errors.New("authenticator app enrollment not started")
+	}
+
+	if totp.Confirmed {
+		return nil, errors.New("authenticator app already enrolled")
+	}
+
+	return auth.TotpQRCode(auth.TotpURI(totpIssuer, student.Email, totp.Secret), 256)
+}
+
+// ConfirmTotp activates a pending enrollment. Recovery codes are returned
+// only when this call is what turns 2FA on for the student.
+func (s *Students) ConfirmTotp(userID int64, code string) ([]string, error) {
+	student, err := s.storage.SelectStudentsByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	totp, err := s.twoFaStorage.SelectTotpSecret(userID)
+	if err != nil {
+		return nil, errors.New("authenticator app enrollment not started")
+	}
+
+	if totp.Confirmed {
+		return nil, errors.New("authenticator app already enrolled")
+	}
+
+	step, ok := auth.ValidateTotp(totp.Secret, code, time.Now())
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	err = s.twoFaStorage.ConfirmTotpSecret(userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storage.RenovationTwoFAMethod(userID, domain.TwoFaMethodTotp)
+	if err != nil {
+		return nil, err
+	}
+
+	if student.TwoFAEnabled {
+		return nil, nil
+	}
+
+	return s.EnableTwoFA(userID)
+}
+
+func (s *Students) SetTwoFAMethod(userID int64, method string) error {
+	switch method {
+	case domain.TwoFaMethodEmail:
+	case domain.TwoFaMethodTotp:
+		totp, err := s.twoFaStorage.SelectTotpSecret(userID)
+		if err != nil || !totp.Confirmed {
+			return errors.New("authenticator app is not enrolled")
+		}
+	default:
+		return errors.New("unsupported two-factor method")
+	}
+
+	return s.storage.RenovationTwoFAMethod(userID, method)
+}
+
 func (s *Students) extractUserIDFromToken(tokenString string) (int64, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.jwtSecret), nil
-	})
+	claims, err := s.keys.Verify(tokenString, auth.TokenTemp, s.options.Issuer, s.options.Issuer)
 	if err != nil {
 		return 0, err
 	}
-	
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, errors.New("Invalid token claims")
+
+	return claims.UserID, nil
+}
+
+// ListSessions returns the active sessions of the user, marking the one the
+// request was made from.
+func (s *Students) ListSessions(userID int64, currentSessionID string) ([]domain.Session, error) {
+	sessions, err := s.storage.SelectSessions(userID)
+	if err != nil {
+		return nil, err
 	}
-	
-	userIDFloat, ok := claims["user_id"].(float64)
-	if !ok {
-		return 0, errors.New("Invalid user_id in token")
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	return sessions, nil
+}
+
+func (s *Students) RevokeSession(userID int64, sessionID string) error {
+	if sessionID == "" {
+		return domain.ErrSessionNotFound
+	}
+	return s.storage.RevokeSession(userID, sessionID)
+}
+
+func (s *Students) LogoutAll(userID int64) error {
+	return s.storage.RevokeAllSessions(userID)
+}
+
+func (s *Students) SetRole(userID int64, role string) error {
+	if !domain.ValidRole(role) {
+		return domain.ErrUnknownRole
+	}
+
+	return s.storage.RenovationRole(userID, role)
+}
+
+// ForgotPassword emails a reset link. Unknown addresses are not reported so
+// the endpoint cannot be used to probe which emails are registered.
+func (s *Students) ForgotPassword(emailAddr string) error {
+	if emailAddr == "" {
+		return errors.New("email is required")
+	}
+
+	student, err := s.storage.SelectStudents(emailAddr)
+	if err != nil {
+		return nil
+	}
+
+	fifteenMinutesAgo := time.Now().Add(-15 * time.Minute)
+	recentRequests, err := s.storage.SelectRecentPasswordResets(student.ID, fifteenMinutesAgo)
+	if err != nil {
+		return err
+	}
+
+	// A rate-limited request looks like any other, otherwise the limit
+	// itself would reveal that the address is registered.
+	if recentRequests >= 3 {
+		fmt.Printf("DEBUG SERVICE FORGOT PASSWORD: Too many reset requests for user %d\n", student.ID)
+		return nil
+	}
+
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return errors.New("failed to generate reset token")
+	}
+
+	msg, err := email.Render(email.TemplatePasswordReset, student.Email, map[string]interface{}{
+		"Name":       student.Firstname,
+		"Link":       strings.TrimRight(s.options.PublicURL, "/") + "/reset-password?token=" + url.QueryEscape(token),
+		"TTLMinutes": int(passwordResetTTL.Minutes()),
+	})
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(passwordResetTTL)
+	return s.storage.InsertPasswordReset(student.ID, auth.HashToken(token), expiresAt, emailNotification(msg))
+}
+
+func (s *Students) ResetPassword(token string, password string) error {
+	if token == "" {
+		return errors.New("invalid or expired reset token")
+	}
+
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("Error hashing password")
 	}
-	
-	return int64(userIDFloat), nil
-}
\ No newline at end of file
+
+	userID, err := s.storage.ResetPassword(auth.HashToken(token), string(hash))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("DEBUG PASSWORD RESET: password changed for user ID: %d\n", userID)
+	return nil
+}
+
+func (s *Students) sendEmailVerification(student domain.Student) error {
+	tokenHash, expiresAt, notification, err := s.newEmailVerification(student)
+	if err != nil {
+		return err
+	}
+	return s.storage.InsertEmailVerification(student.ID, tokenHash, expiresAt, notification)
+}
+
+// newEmailVerification creates a verification token and the email carrying
+// it. Only the token hash is stored.
+func (s *Students) newEmailVerification(student domain.Student) (string, time.Time, domain.OutboxMessage, error) {
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return "", time.Time{}, domain.OutboxMessage{}, errors.New("failed to generate verification token")
+	}
+
+	msg, err := email.Render(email.TemplateEmailVerification, student.Email, map[string]interface{}{
+		"Name":     student.Firstname,
+		"Link":     strings.TrimRight(s.options.PublicURL, "/") + "/verify-email?token=" + url.QueryEscape(token),
+		"TTLHours": int(emailVerifyTTL.Hours()),
+	})
+	if err != nil {
+		return "", time.Time{}, domain.OutboxMessage{}, err
+	}
+
+	return auth.HashToken(token), time.Now().Add(emailVerifyTTL), emailNotification(msg), nil
+}
+
+func (s *Students) VerifyEmail(token string) error {
+	if token == "" {
+		return errors.New("invalid or expired verification token")
+	}
+
+	userID, err := s.storage.VerifyEmail(auth.HashToken(token))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("DEBUG VERIFY EMAIL: email verified for user ID: %d\n", userID)
+	return nil
+}
+
+// ResendEmailVerification is silent about unknown or already verified
+// addresses for the same reason as ForgotPassword.
+func (s *Students) ResendEmailVerification(emailAddr string) error {
+	if emailAddr == "" {
+		return errors.New("email is required")
+	}
+
+	student, err := s.storage.SelectStudents(emailAddr)
+	if err != nil || student.EmailVerified {
+		return nil
+	}
+
+	fifteenMinutesAgo := time.Now().Add(-15 * time.Minute)
+	recentRequests, err := s.storage.SelectRecentEmailVerifications(student.ID, fifteenMinutesAgo)
+	if err != nil {
+		return err
+	}
+
+	// Throttled requests get the same answer as every other address.
+	if recentRequests >= 3 {
+		fmt.Printf("DEBUG SERVICE RESEND VERIFICATION: Too many verification requests for user %d\n", student.ID)
+		return nil
+	}
+
+	return s.sendEmailVerification(student)
+}
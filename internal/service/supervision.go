@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gosmol/internal/domain"
+	"strings"
+)
+
+func (d *Diplomas) GetSupervisors() ([]domain.Supervisor, error) {
+	return d.storage.SelectSupervisors()
+}
+
+func (d *Diplomas) SetSupervisorCapacity(supervisorID int64, capacity int) error {
+	if capacity < 0 {
+		return errors.New("capacity must not be negative")
+	}
+	return d.storage.RenovationSupervisorCapacity(supervisorID, capacity)
+}
+
+// RequestSupervision asks a supervisor to take the actor's diploma. A full
+// supervisor is refused up front; capacity is checked again on accept.
+func (d *Diplomas) RequestSupervision(diplomaID int64, input domain.SupervisionRequestInput, actor domain.Actor) (domain.SupervisionRequest, error) {
+	diploma, err := d.storage.SelectResource(diplomaID)
+	if err != nil {
+		return domain.SupervisionRequest{}, err
+	}
+	if diploma.OwnerID != actor.ID {
+		return domain.SupervisionRequest{}, domain.ErrForbidden
+	}
+	if diploma.SupervisorID != nil {
+		return domain.SupervisionRequest{}, domain.ErrAlreadySupervised
+	}
+
+	supervisor, err := d.storage.SelectSupervisor(input.SupervisorID)
+	if err != nil {
+		return domain.SupervisionRequest{}, err
+	}
+	if supervisor.Assigned >= supervisor.Capacity {
+		return domain.SupervisionRequest{}, domain.ErrCapacityReached
+	}
+
+	if len(input.Message) > 1000 {
+		return domain.SupervisionRequest{}, errors.New("message too long")
+	}
+
+	id, err := d.storage.InsertSupervisionRequest(domain.SupervisionRequest{
+		DiplomaID:    diplomaID,
+		StudentID:    actor.ID,
+		SupervisorID: supervisor.ID,
+		Message:      strings.TrimSpace(input.Message),
+	})
+	if err != nil {
+		return domain.SupervisionRequest{}, err
+	}
+
+	return d.storage.SelectSupervisionRequest(id)
+}
+
+// GetSupervisionRequests lists the requests sent to the supervisor, filtered
+// by status when one is given.
+func (d *Diplomas) GetSupervisionRequests(supervisorID int64, status string) ([]domain.SupervisionRequest, error) {
+	switch status {
+	case "", domain.SupervisionPending, domain.SupervisionAccepted, domain.SupervisionDeclined:
+	default:
+		return nil, fmt.Errorf("%w: unknown request status %q", domain.ErrInvalidQuery, status)
+	}
+	return d.storage.SelectSupervisionRequests(supervisorID, status)
+}
+
+func (d *Diplomas) GetSupervisedResources(supervisorID int64) ([]domain.Diploma, error) {
+	return d.storage.SelectResourcesBySupervisor(supervisorID)
+}
+
+// DecideSupervisionRequest accepts or declines a request. Only the addressed
+// supervisor may decide it.
+func (d *Diplomas) DecideSupervisionRequest(id int64, accept bool, reason string, actor domain.Actor) (domain.SupervisionRequest, error) {
+	request, err := d.storage.SelectSupervisionRequest(id)
+	if err != nil {
+		return domain.SupervisionRequest{}, err
+	}
+	if request.SupervisorID != actor.ID {
+		return domain.SupervisionRequest{}, domain.ErrForbidden
+	}
+	if request.Status != domain.SupervisionPending {
+		return domain.SupervisionRequest{}, domain.ErrRequestDecided
+	}
+
+	if accept {
+		err = d.storage.AcceptSupervisionRequest(id)
+	} else {
+		err = d.storage.DeclineSupervisionRequest(id, strings.TrimSpace(reason))
+	}
+	if err != nil {
+		return domain.SupervisionRequest{}, err
+	}
+
+	return d.storage.SelectSupervisionRequest(id)
+}
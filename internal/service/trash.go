@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"gosmol/pkg/filestorage"
+	"time"
+)
+
+type TrashStorage interface {
+	PurgeResources(deletedBefore time.Time) (int64, []string, error)
+}
+
+type TrashOptions struct {
+	// Retention is how long a deleted diploma can still be restored.
+	Retention     time.Duration
+	PurgeInterval time.Duration
+}
+
+// Trash purges deleted diplomas once their retention period is over, with
+// the files attached to them.
+type Trash struct {
+	storage TrashStorage
+	blobs   filestorage.Storage
+	options TrashOptions
+}
+
+func NewTrash(storage TrashStorage, blobs filestorage.Storage, options TrashOptions) *Trash {
+	return &Trash{storage: storage, blobs: blobs, options: options}
+}
+
+// Run purges the trash every PurgeInterval until ctx is cancelled.
+func (t *Trash) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.options.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := t.PurgeOnce(ctx); err != nil {
+			fmt.Printf("DEBUG TRASH: purge failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce hard-deletes the diplomas past retention and reports how many
+// were removed. A blob that fails to delete is logged and left behind, as
+// its row is already gone.
+func (t *Trash) PurgeOnce(ctx context.Context) (int64, error) {
+	purged, keys, err := t.storage.PurgeResources(time.Now().Add(-t.options.Retention))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		if err := t.blobs.Delete(ctx, key); err != nil {
+			fmt.Printf("DEBUG TRASH: failed to delete file %s: %v\n", key, err)
+		}
+	}
+
+	if purged > 0 {
+		fmt.Printf("DEBUG TRASH: purged %d diplomas and %d files\n", purged, len(keys))
+	}
+	return purged, nil
+}
@@ -2,9 +2,12 @@ package psql
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gosmol/internal/domain"
+	"time"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -16,97 +19,324 @@ func NewDiplomasRepo(db *pgxpool.Pool) *DiplomasRepo {
 	return &DiplomasRepo{db: db}
 }
 
-func (d *DiplomasRepo) SelectAllResource(limits int64, page int64) ([]domain.Diploma, error) {
-    offset := (page - 1) * limits
-    fmt.Printf("DEBUG DIPLOMAS: Getting all diplomas, limit: %d, offset: %d\n", limits, offset)
-    
-    rows, err := d.db.Query(context.Background(), 
-        "SELECT id, title, description FROM diplomas LIMIT $1 OFFSET $2", limits, offset)
-    if err != nil {
-        fmt.Printf("DEBUG DIPLOMAS: Error querying diplomas: %v\n", err)
-        return nil, err
-    }
-    defer rows.Close()
-
-    var diplomas []domain.Diploma
-    for rows.Next() {
-        var diploma domain.Diploma
-        if err := rows.Scan(&diploma.ID, &diploma.Title, &diploma.Description); err != nil {
-            fmt.Printf("DEBUG DIPLOMAS: Error scanning diploma: %v\n", err)
-            return nil, err
-        }
-        fmt.Printf("DEBUG DIPLOMAS: Found diploma - ID: %d, Title: %s\n", diploma.ID, diploma.Title)
-        diplomas = append(diplomas, diploma)
-    }    
-
-    fmt.Printf("DEBUG DIPLOMAS: Total diplomas found: %d\n", len(diplomas))
-    return diplomas, nil
+// diplomaColumns selects a diploma with its owner. Only public student columns
+// are joined, password and 2FA data never leave the users table.
+const diplomaColumns = `
+    d.id, d.title, COALESCE(d.description, ''), d.status, d.owner_id,
+    u.firstname, u.lastname, u.email, d.supervisor_id, d.final_grade, d.created_at, d.updated_at, d.version,
+    d.deleted_at`
+
+const diplomaTables = `
+    FROM diplomas d
+    LEFT JOIN users u ON u.id = d.owner_id`
+
+func scanDiploma(row pgx.Row, extra ...interface{}) (domain.Diploma, error) {
+	var diploma domain.Diploma
+	var ownerID *int64
+	var firstname, lastname, email *string
+	dest := []interface{}{&diploma.ID, &diploma.Title, &diploma.Description, &diploma.Status, &ownerID,
+		&firstname, &lastname, &email, &diploma.SupervisorID, &diploma.FinalGrade, &diploma.CreatedAt, &diploma.UpdatedAt,
+		&diploma.Version, &diploma.DeletedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+
+	if ownerID != nil {
+		diploma.OwnerID = *ownerID
+		diploma.Student = &domain.StudentProfile{ID: *ownerID}
+		if firstname != nil {
+			diploma.Student.Firstname = *firstname
+			diploma.Student.Lastname = *lastname
+			diploma.Student.Email = *email
+		}
+	}
+
+	return diploma, nil
+}
+
+func (d *DiplomasRepo) SelectResourcesByOwner(ownerID int64) ([]domain.Diploma, error) {
+	fmt.Printf("DEBUG DIPLOMAS: Getting diplomas of student ID: %d\n", ownerID)
+
+	rows, err := d.db.Query(context.Background(),
+		"SELECT "+diplomaColumns+diplomaTables+" WHERE d.owner_id = $1 AND d.deleted_at IS NULL ORDER BY d.id", ownerID)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error querying diplomas: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	diplomas := []domain.Diploma{}
+	for rows.Next() {
+		diploma, err := scanDiploma(rows)
+		if err != nil {
+			fmt.Printf("DEBUG DIPLOMAS: Error scanning diploma: %v\n", err)
+			return nil, err
+		}
+		diplomas = append(diplomas, diploma)
+	}
+
+	return diplomas, rows.Err()
 }
 
 func (d *DiplomasRepo) InsertResource(diploma domain.Diploma) (int64, error) {
-    var id int64
-    fmt.Printf("DEBUG DIPLOMA INSERT: Starting - Title: %s\n", diploma.Title)
-    
-    query := `INSERT INTO diplomas (title, description) VALUES ($1, $2) RETURNING id`
-    
-    err := d.db.QueryRow(context.Background(), query, diploma.Title, diploma.Description).Scan(&id)
-    if err != nil {
-        fmt.Printf("DEBUG DIPLOMA INSERT: ERROR: %v\n", err)
-        return 0, err
-    }
-    
-    fmt.Printf("DEBUG DIPLOMA INSERT: SUCCESS - Inserted with ID: %d\n", id)
-    return id, nil
+	var id int64
+	fmt.Printf("DEBUG DIPLOMA INSERT: Starting - Title: %s\n", diploma.Title)
+
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `INSERT INTO diplomas (title, description, owner_id) VALUES ($1, $2, NULLIF($3, 0)) RETURNING id`
+
+	err = tx.QueryRow(ctx, query, diploma.Title, diploma.Description, diploma.OwnerID).Scan(&id)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMA INSERT: ERROR: %v\n", err)
+		return 0, err
+	}
+
+	if err := insertRevision(ctx, tx, id, domain.RevisionCreate, diploma.OwnerID); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("DEBUG DIPLOMA INSERT: SUCCESS - Inserted with ID: %d\n", id)
+	return id, tx.Commit(ctx)
 }
 
 func (d *DiplomasRepo) SelectResource(id int64) (domain.Diploma, error) {
-    var diploma domain.Diploma
-    fmt.Printf("DEBUG DIPLOMA SELECT: Getting diploma by ID: %d\n", id)
-    
-    err := d.db.QueryRow(context.Background(), 
-        "SELECT id, title, description FROM diplomas WHERE id = $1", id).
-        Scan(&diploma.ID, &diploma.Title, &diploma.Description)
-
-    if err != nil {
-        fmt.Printf("DEBUG DIPLOMA SELECT: ERROR: %v\n", err)
-        return domain.Diploma{}, err
-    }
-    
-    fmt.Printf("DEBUG DIPLOMA SELECT: SUCCESS - Diploma ID: %d, Title: %s\n", diploma.ID, diploma.Title)
-    return diploma, nil
+	fmt.Printf("DEBUG DIPLOMA SELECT: Getting diploma by ID: %d\n", id)
+
+	diploma, err := scanDiploma(d.db.QueryRow(context.Background(),
+		"SELECT "+diplomaColumns+diplomaTables+" WHERE d.id = $1 AND d.deleted_at IS NULL", id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Diploma{}, domain.ErrDiplomaNotFound
+	}
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMA SELECT: ERROR: %v\n", err)
+		return domain.Diploma{}, err
+	}
+
+	fmt.Printf("DEBUG DIPLOMA SELECT: SUCCESS - Diploma ID: %d, Title: %s\n", diploma.ID, diploma.Title)
+	return diploma, nil
+}
+
+// RenovationResource updates a draft diploma, bumps its version and records
+// the revision as action by actorID. A non-empty ifMatch lists the versions
+// the caller expects the row to still be at; the check happens in the UPDATE
+// itself so concurrent edits cannot both pass it.
+func (d *DiplomasRepo) RenovationResource(id int64, diploma domain.Diploma, ifMatch []int64, actorID int64, action string) (domain.Diploma, error) {
+	fmt.Printf("DEBUG STORAGE DIPLOMA UPDATE: Updating diploma ID: %d\n", id)
+
+	updatedDiploma := domain.Diploma{
+		ID:          id,
+		Title:       diploma.Title,
+		Description: diploma.Description,
+	}
+
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return domain.Diploma{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+        UPDATE diplomas SET title = $1, description = $2, version = version + 1, updated_at = NOW()
+        WHERE id = $3 AND status = $4 AND deleted_at IS NULL AND (cardinality($5::bigint[]) = 0 OR version = ANY($5))
+        RETURNING version, updated_at`,
+		diploma.Title, diploma.Description, id, domain.DiplomaStatusDraft, versionsArg(ifMatch)).
+		Scan(&updatedDiploma.Version, &updatedDiploma.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Diploma{}, d.whyUnchanged(id, ifMatch)
+	}
+	if err != nil {
+		fmt.Printf("DEBUG STORAGE DIPLOMA UPDATE: ERROR: %v\n", err)
+		return domain.Diploma{}, err
+	}
+
+	if err := insertRevision(ctx, tx, id, action, actorID); err != nil {
+		return domain.Diploma{}, err
+	}
+
+	fmt.Printf("DEBUG STORAGE DIPLOMA UPDATE: SUCCESS - Updated diploma ID: %d to version %d\n", id, updatedDiploma.Version)
+	return updatedDiploma, tx.Commit(ctx)
+}
+
+// DestroyResource moves a diploma to the trash. It stays there, hidden from
+// every read, until it is restored or purged.
+func (d *DiplomasRepo) DestroyResource(id int64, ifMatch []int64, actorID int64) error {
+	fmt.Printf("DEBUG DIPLOMAS: Deleting diploma ID: %d\n", id)
+
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+        UPDATE diplomas SET deleted_at = NOW(), deleted_by = NULLIF($3, 0), version = version + 1
+        WHERE id = $1 AND deleted_at IS NULL AND (cardinality($2::bigint[]) = 0 OR version = ANY($2))`,
+		id, versionsArg(ifMatch), actorID)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error deleting diploma: %v\n", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return d.whyUnchanged(id, ifMatch)
+	}
+
+	if err := insertRevision(ctx, tx, id, domain.RevisionDelete, actorID); err != nil {
+		return err
+	}
+
+	fmt.Printf("DEBUG DIPLOMAS: Diploma %d moved to trash by user %d\n", id, actorID)
+	return tx.Commit(ctx)
 }
 
-func (d *DiplomasRepo) RenovationResource(id int64, diploma domain.Diploma) (domain.Diploma, error) {
-    fmt.Printf("DEBUG STORAGE DIPLOMA UPDATE: Updating diploma ID: %d\n", id)
-    
-    _, err := d.db.Exec(context.Background(),
-        "UPDATE diplomas SET title = $1, description = $2 WHERE id = $3",
-        diploma.Title, diploma.Description, id)
-
-    if err != nil {
-        fmt.Printf("DEBUG STORAGE DIPLOMA UPDATE: ERROR: %v\n", err)
-        return domain.Diploma{}, err
-    }
-    
-    updatedDiploma := domain.Diploma{
-        ID:          id,
-        Title:       diploma.Title,
-        Description: diploma.Description,
-    }
-    
-    fmt.Printf("DEBUG STORAGE DIPLOMA UPDATE: SUCCESS - Updated diploma ID: %d\n", id)
-    return updatedDiploma, nil
+func (d *DiplomasRepo) RestoreResource(id int64, actorID int64) error {
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE diplomas SET deleted_at = NULL, deleted_by = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrDiplomaNotFound
+	}
+
+	if err := insertRevision(ctx, tx, id, domain.RevisionRestore, actorID); err != nil {
+		return err
+	}
+
+	fmt.Printf("DEBUG DIPLOMAS: Diploma %d restored from trash by user %d\n", id, actorID)
+	return tx.Commit(ctx)
+}
+
+// PurgeResources hard-deletes diplomas that went to the trash before the
+// given time. Their files go with them by cascade; the storage keys of those
+// files are returned so the blobs can be removed as well.
+func (d *DiplomasRepo) PurgeResources(deletedBefore time.Time) (int64, []string, error) {
+	var purged int64
+	var keys []string
+	err := d.db.QueryRow(context.Background(), `
+		WITH purged AS (
+			DELETE FROM diplomas WHERE deleted_at < $1 RETURNING id
+		)
+		SELECT
+			(SELECT COUNT(*) FROM purged),
+			ARRAY(SELECT f.storage_key FROM diploma_files f WHERE f.diploma_id IN (SELECT id FROM purged))`,
+		deletedBefore).Scan(&purged, &keys)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return purged, keys, nil
 }
 
-func (d *DiplomasRepo) DestroyResource(id int64) error {
-    fmt.Printf("DEBUG DIPLOMAS: Deleting diploma ID: %d\n", id)
-    
-    _, err := d.db.Exec(context.Background(), "DELETE FROM diplomas WHERE id = $1", id)   
-    if err != nil {
-        fmt.Printf("DEBUG DIPLOMAS: Error deleting diploma: %v\n", err)
-        return err
-    }
-    
-    fmt.Printf("DEBUG DIPLOMAS: Diploma deleted successfully\n")
-    return nil
-}
\ No newline at end of file
+// whyUnchanged explains a write that matched no row: the diploma is gone,
+// it moved past the expected version, or it is no longer a draft.
+func (d *DiplomasRepo) whyUnchanged(id int64, ifMatch []int64) error {
+	var status string
+	var version int64
+	err := d.db.QueryRow(context.Background(),
+		"SELECT status, version FROM diplomas WHERE id = $1 AND deleted_at IS NULL", id).Scan(&status, &version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrDiplomaNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(ifMatch) > 0 {
+		matched := false
+		for _, v := range ifMatch {
+			matched = matched || v == version
+		}
+		if !matched {
+			return domain.ErrVersionMismatch
+		}
+	}
+	return domain.ErrDiplomaLocked
+}
+
+// versionsArg keeps an empty list from being sent as NULL, which
+// cardinality would not report as 0.
+func versionsArg(versions []int64) []int64 {
+	if versions == nil {
+		return []int64{}
+	}
+	return versions
+}
+
+// TransitionResource moves a diploma from one status to another and records
+// the change in its history and as a revision. The status is compared again in the UPDATE so
+// two concurrent transitions cannot both succeed.
+func (d *DiplomasRepo) TransitionResource(id int64, from string, to string, actorID int64, reason string) error {
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE diplomas SET status = $1, version = version + 1, updated_at = NOW()
+		WHERE id = $2 AND status = $3 AND deleted_at IS NULL`, to, id, from)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrInvalidTransition
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO diploma_status_history (diploma_id, from_status, to_status, actor_id, reason)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))`, id, from, to, actorID, reason)
+	if err != nil {
+		return err
+	}
+
+	if err := insertRevision(ctx, tx, id, domain.RevisionTransition, actorID); err != nil {
+		return err
+	}
+
+	fmt.Printf("DEBUG DIPLOMA STATUS: Diploma %d moved %s -> %s by user %d\n", id, from, to, actorID)
+	return tx.Commit(ctx)
+}
+
+func (d *DiplomasRepo) SelectStatusHistory(id int64) ([]domain.DiplomaStatusChange, error) {
+	rows, err := d.db.Query(context.Background(), `
+		SELECT id, diploma_id, from_status, to_status, COALESCE(actor_id, 0), COALESCE(reason, ''), created_at
+		FROM diploma_status_history
+		WHERE diploma_id = $1
+		ORDER BY created_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []domain.DiplomaStatusChange{}
+	for rows.Next() {
+		var change domain.DiplomaStatusChange
+		err := rows.Scan(&change.ID, &change.DiplomaID, &change.FromStatus, &change.ToStatus,
+			&change.ActorID, &change.Reason, &change.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
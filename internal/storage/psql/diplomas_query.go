@@ -0,0 +1,256 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"gosmol/internal/domain"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// diplomaSearchCTE parses the search query with both stemmers, so Russian and
+// English word forms find the same diploma. It always takes $1.
+const diplomaSearchCTE = `
+    WITH q AS (SELECT websearch_to_tsquery('russian', $1) || websearch_to_tsquery('english', $1) AS query)`
+
+// diplomaSearchColumns adds the rank and a highlighted headline cut from the
+// description, or the title when there is none.
+const diplomaSearchColumns = `,
+    ts_rank(d.search_vector, q.query)::float8 AS rank,
+    ts_headline('russian', COALESCE(NULLIF(d.description, ''), d.title), q.query,
+        'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2')`
+
+type sortKey struct {
+	column string
+	// cast is the type a cursor key, which always travels as text, is
+	// converted to before it is compared with the column.
+	cast string
+}
+
+// diplomaSorts maps the sort fields accepted by the API to SQL expressions.
+var diplomaSorts = map[string]sortKey{
+	"id":         {"d.id", "bigint"},
+	"title":      {"d.title", "text"},
+	"status":     {"d.status", "text"},
+	"created_at": {"d.created_at", "timestamp"},
+	"updated_at": {"d.updated_at", "timestamp"},
+	"rank":       {"ts_rank(d.search_vector, q.query)::float8", "float8"},
+	"deleted_at": {"d.deleted_at", "timestamp"},
+}
+
+func sortKeyOf(query domain.DiplomaQuery) sortKey {
+	key, ok := diplomaSorts[query.Sort]
+	if !ok {
+		return diplomaSorts["id"]
+	}
+	return key
+}
+
+// diplomaListing is a listing query split into the parts the page and count
+// queries share. Arguments are numbered in the order they were added.
+type diplomaListing struct {
+	with    string
+	columns string
+	from    string
+	args    []interface{}
+}
+
+func newDiplomaListing(query domain.DiplomaQuery) *diplomaListing {
+	l := &diplomaListing{from: diplomaTables}
+	conds := []string{"d.deleted_at IS NULL"}
+	if query.Deleted {
+		conds[0] = "d.deleted_at IS NOT NULL"
+	}
+
+	if query.Q != "" {
+		l.with = diplomaSearchCTE
+		l.columns = diplomaSearchColumns
+		l.from += "\n    CROSS JOIN q"
+		l.args = append(l.args, query.Q)
+		conds = append(conds, "d.search_vector @@ q.query")
+	}
+	if query.Status != "" {
+		conds = append(conds, "d.status = "+l.arg(query.Status))
+	}
+	if query.OwnerID != 0 {
+		conds = append(conds, "d.owner_id = "+l.arg(query.OwnerID))
+	}
+	if query.SupervisorID != 0 {
+		conds = append(conds, "d.supervisor_id = "+l.arg(query.SupervisorID))
+	}
+	if query.CreatedFrom != nil {
+		conds = append(conds, "d.created_at >= "+l.arg(*query.CreatedFrom))
+	}
+	if query.CreatedTo != nil {
+		conds = append(conds, "d.created_at < "+l.arg(*query.CreatedTo))
+	}
+
+	if query.After != nil {
+		// Rows strictly after the cursor in the listing order. Sort key and id
+		// always share a direction, so a row comparison covers the tiebreaker.
+		key := sortKeyOf(query)
+		op := ">"
+		if query.Order == domain.SortDesc {
+			op = "<"
+		}
+		if key.column == "d.id" {
+			conds = append(conds, "d.id "+op+" "+l.arg(query.After.ID))
+		} else {
+			conds = append(conds, fmt.Sprintf("(%s, d.id) %s (%s::text::%s, %s)",
+				key.column, op, l.arg(query.After.Key), key.cast, l.arg(query.After.ID)))
+		}
+	}
+
+	l.from += "\n    WHERE " + strings.Join(conds, " AND ")
+	return l
+}
+
+func (l *diplomaListing) arg(value interface{}) string {
+	l.args = append(l.args, value)
+	return fmt.Sprintf("$%d", len(l.args))
+}
+
+// orderBy sorts by the requested field with the id as a tiebreaker, so rows
+// with equal keys keep a stable order between pages.
+func orderBy(query domain.DiplomaQuery) string {
+	column := sortKeyOf(query).column
+	direction := "ASC"
+	if query.Order == domain.SortDesc {
+		direction = "DESC"
+	}
+	if column == "d.id" {
+		return " ORDER BY d.id " + direction
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, d.id %s", column, direction, direction)
+}
+
+// SelectAllResource returns one page of diplomas matching the query and the
+// number of matching diplomas across all pages.
+func (d *DiplomasRepo) SelectAllResource(query domain.DiplomaQuery) ([]domain.Diploma, int64, error) {
+	ctx := context.Background()
+	offset := (query.Page - 1) * query.Limit
+	fmt.Printf("DEBUG DIPLOMAS: Getting diplomas, limit: %d, offset: %d, q: %q\n", query.Limit, offset, query.Q)
+
+	listing := newDiplomaListing(query)
+
+	var total int64
+	err := d.db.QueryRow(ctx, listing.with+" SELECT COUNT(*)"+listing.from, listing.args...).Scan(&total)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error counting diplomas: %v\n", err)
+		return nil, 0, err
+	}
+
+	sql := listing.with + " SELECT " + diplomaColumns + listing.columns + listing.from + orderBy(query) +
+		" LIMIT " + listing.arg(query.Limit) + " OFFSET " + listing.arg(offset)
+	rows, err := d.db.Query(ctx, sql, listing.args...)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error querying diplomas: %v\n", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	diplomas, err := scanListing(rows, query)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error scanning diploma: %v\n", err)
+		return nil, 0, err
+	}
+
+	fmt.Printf("DEBUG DIPLOMAS: Found %d of %d diplomas\n", len(diplomas), total)
+	return diplomas, total, nil
+}
+
+// SelectResourcesAfter returns up to query.Limit diplomas following the
+// query.After cursor. Unlike SelectAllResource it never counts the matches.
+func (d *DiplomasRepo) SelectResourcesAfter(query domain.DiplomaQuery) ([]domain.Diploma, error) {
+	fmt.Printf("DEBUG DIPLOMAS: Getting diplomas after id %d, limit: %d, q: %q\n", query.After.ID, query.Limit, query.Q)
+
+	listing := newDiplomaListing(query)
+	sql := listing.with + " SELECT " + diplomaColumns + listing.columns + listing.from + orderBy(query) +
+		" LIMIT " + listing.arg(query.Limit)
+	rows, err := d.db.Query(context.Background(), sql, listing.args...)
+	if err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error querying diplomas: %v\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListing(rows, query)
+}
+
+func scanListing(rows pgx.Rows, query domain.DiplomaQuery) ([]domain.Diploma, error) {
+	diplomas := []domain.Diploma{}
+	for rows.Next() {
+		if query.Q == "" {
+			diploma, err := scanDiploma(rows)
+			if err != nil {
+				return nil, err
+			}
+			diplomas = append(diplomas, diploma)
+			continue
+		}
+
+		var rank float64
+		var headline string
+		diploma, err := scanDiploma(rows, &rank, &headline)
+		if err != nil {
+			return nil, err
+		}
+		diploma.Rank, diploma.Headline = &rank, headline
+		diplomas = append(diplomas, diploma)
+	}
+
+	return diplomas, rows.Err()
+}
+
+// exportBatch is how many rows ExportResources fetches from its cursor at a
+// time.
+const exportBatch = 500
+
+// ExportResources streams every diploma matching the query to emit in
+// listing order. The rows come from a server-side cursor in a read-only
+// transaction, so only one batch is held in memory. An error from emit stops
+// the export.
+func (d *DiplomasRepo) ExportResources(query domain.DiplomaQuery, emit func(domain.Diploma) error) error {
+	ctx := context.Background()
+	tx, err := d.db.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	listing := newDiplomaListing(query)
+	sql := "DECLARE diploma_export NO SCROLL CURSOR FOR " +
+		listing.with + " SELECT " + diplomaColumns + listing.columns + listing.from + orderBy(query)
+	if _, err := tx.Exec(ctx, sql, listing.args...); err != nil {
+		fmt.Printf("DEBUG DIPLOMAS: Error declaring export cursor: %v\n", err)
+		return err
+	}
+
+	exported := 0
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM diploma_export", exportBatch))
+		if err != nil {
+			return err
+		}
+		diplomas, err := scanListing(rows, query)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, diploma := range diplomas {
+			if err := emit(diploma); err != nil {
+				return err
+			}
+		}
+		exported += len(diplomas)
+
+		if len(diplomas) < exportBatch {
+			break
+		}
+	}
+
+	fmt.Printf("DEBUG DIPLOMAS: Exported %d diplomas\n", exported)
+	return nil
+}
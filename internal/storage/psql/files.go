@@ -0,0 +1,69 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"gosmol/internal/domain"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+type FilesRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewFilesRepo(db *pgxpool.Pool) *FilesRepo {
+	return &FilesRepo{db: db}
+}
+
+const fileColumns = `
+	id, diploma_id, storage_key, filename, content_type, size, sha256, COALESCE(uploaded_by, 0), created_at
+	FROM diploma_files`
+
+func scanFile(row pgx.Row) (domain.DiplomaFile, error) {
+	var file domain.DiplomaFile
+	err := row.Scan(&file.ID, &file.DiplomaID, &file.StorageKey, &file.Filename, &file.ContentType,
+		&file.Size, &file.SHA256, &file.UploadedBy, &file.CreatedAt)
+	return file, err
+}
+
+func (f *FilesRepo) InsertFile(file domain.DiplomaFile) (int64, error) {
+	var id int64
+	err := f.db.QueryRow(context.Background(), `
+		INSERT INTO diploma_files (diploma_id, storage_key, filename, content_type, size, sha256, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		file.DiplomaID, file.StorageKey, file.Filename, file.ContentType, file.Size, file.SHA256, file.UploadedBy).
+		Scan(&id)
+	return id, err
+}
+
+func (f *FilesRepo) SelectFiles(diplomaID int64) ([]domain.DiplomaFile, error) {
+	rows, err := f.db.Query(context.Background(),
+		"SELECT "+fileColumns+" WHERE diploma_id = $1 ORDER BY created_at, id", diplomaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []domain.DiplomaFile{}
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	return files, rows.Err()
+}
+
+func (f *FilesRepo) SelectFile(diplomaID int64, fileID int64) (domain.DiplomaFile, error) {
+	file, err := scanFile(f.db.QueryRow(context.Background(),
+		"SELECT "+fileColumns+" WHERE id = $1 AND diploma_id = $2", fileID, diplomaID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.DiplomaFile{}, domain.ErrFileNotFound
+	}
+	return file, err
+}
@@ -0,0 +1,103 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"gosmol/internal/domain"
+	"sort"
+)
+
+// SelectImportUsers finds the users behind the given lower-case emails along
+// with their supervisor load.
+func (d *DiplomasRepo) SelectImportUsers(emails []string) ([]domain.ImportUser, error) {
+	rows, err := d.db.Query(context.Background(), `
+		SELECT u.id, u.email, u.role, u.supervisor_capacity,
+			(SELECT COUNT(*) FROM diplomas d WHERE d.supervisor_id = u.id AND d.status <> 'defended' AND d.deleted_at IS NULL)
+		FROM users u
+		WHERE LOWER(u.email) = ANY($1)`, emails)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []domain.ImportUser{}
+	for rows.Next() {
+		var user domain.ImportUser
+		if err := rows.Scan(&user.ID, &user.Email, &user.Role, &user.Capacity, &user.Assigned); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// InsertResources inserts diplomas in one transaction with a create revision
+// each. The supervisors involved are locked and their capacity checked again,
+// as in AcceptSupervisionRequest. Unless commit is set the transaction is
+// rolled back after the last insert.
+func (d *DiplomasRepo) InsertResources(diplomas []domain.Diploma, commit bool) ([]int64, error) {
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Supervisors are locked in id order so concurrent imports cannot deadlock.
+	load := make(map[int64]int)
+	var supervisors []int64
+	for _, diploma := range diplomas {
+		if diploma.SupervisorID == nil {
+			continue
+		}
+		if load[*diploma.SupervisorID] == 0 {
+			supervisors = append(supervisors, *diploma.SupervisorID)
+		}
+		load[*diploma.SupervisorID]++
+	}
+	sort.Slice(supervisors, func(i, j int) bool { return supervisors[i] < supervisors[j] })
+
+	for _, supervisorID := range supervisors {
+		added := load[supervisorID]
+		var capacity, assigned int
+		err := tx.QueryRow(ctx, `SELECT supervisor_capacity FROM users WHERE id = $1 FOR UPDATE`, supervisorID).
+			Scan(&capacity)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow(ctx,
+			`SELECT COUNT(*) FROM diplomas WHERE supervisor_id = $1 AND status <> 'defended' AND deleted_at IS NULL`, supervisorID).
+			Scan(&assigned)
+		if err != nil {
+			return nil, err
+		}
+		if assigned+added > capacity {
+			return nil, fmt.Errorf("supervisor %d: %w", supervisorID, domain.ErrCapacityReached)
+		}
+	}
+
+	ids := make([]int64, 0, len(diplomas))
+	for _, diploma := range diplomas {
+		var id int64
+		err := tx.QueryRow(ctx,
+			`INSERT INTO diplomas (title, description, owner_id, supervisor_id) VALUES ($1, $2, NULLIF($3, 0), $4) RETURNING id`,
+			diploma.Title, diploma.Description, diploma.OwnerID, diploma.SupervisorID).Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("insert %q: %w", diploma.Title, err)
+		}
+
+		if err := insertRevision(ctx, tx, id, domain.RevisionCreate, diploma.OwnerID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if !commit {
+		fmt.Printf("DEBUG DIPLOMA IMPORT: Dry run, rolling back %d inserts\n", len(ids))
+		return ids, nil
+	}
+
+	fmt.Printf("DEBUG DIPLOMA IMPORT: SUCCESS - Inserted %d diplomas\n", len(ids))
+	return ids, tx.Commit(ctx)
+}
@@ -0,0 +1,152 @@
+package psql
+
+import (
+	"context"
+	"gosmol/internal/domain"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const outboxColumns = `id, kind, recipient, subject, COALESCE(body_text, ''), COALESCE(body_html, ''), status,
+	attempts, max_attempts, next_attempt_at, COALESCE(last_error, ''), created_at, sent_at`
+
+type OutboxRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewOutboxRepo(db *pgxpool.Pool) *OutboxRepo {
+	return &OutboxRepo{db: db}
+}
+
+// insertOutbox enqueues msg inside tx so it is committed together with the
+// domain change that produced it.
+func insertOutbox(ctx context.Context, tx pgx.Tx, msg domain.OutboxMessage) error {
+	maxAttempts := msg.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 5
+	}
+
+	q := `
+		INSERT INTO outbox (kind, recipient, subject, body_text, body_html, max_attempts)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := tx.Exec(ctx, q, msg.Kind, msg.Recipient, msg.Subject, msg.BodyText, msg.BodyHTML, maxAttempts)
+	return err
+}
+
+func scanOutbox(row pgx.Row) (domain.OutboxMessage, error) {
+	var msg domain.OutboxMessage
+	err := row.Scan(&msg.ID, &msg.Kind, &msg.Recipient, &msg.Subject, &msg.BodyText, &msg.BodyHTML,
+		&msg.Status, &msg.Attempts, &msg.MaxAttempts, &msg.NextAttemptAt, &msg.LastError,
+		&msg.CreatedAt, &msg.SentAt)
+	return msg, err
+}
+
+// ClaimOutbox leases up to limit due messages. SKIP LOCKED together with the
+// lease lets several app instances run dispatchers side by side.
+func (o *OutboxRepo) ClaimOutbox(limit int, lease time.Duration) ([]domain.OutboxMessage, error) {
+	q := `
+		UPDATE outbox SET locked_until = NOW() + $2 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+				AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxColumns
+
+	rows, err := o.db.Query(context.Background(), q, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []domain.OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutbox(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// MarkOutboxSent also drops the message bodies: they hold 2FA codes and
+// reset or verification links that must not outlive delivery in plaintext.
+func (o *OutboxRepo) MarkOutboxSent(id int64) error {
+	q := `UPDATE outbox SET status = 'sent', attempts = attempts + 1, sent_at = NOW(), locked_until = NULL, last_error = NULL,
+		body_text = NULL, body_html = NULL WHERE id = $1`
+	_, err := o.db.Exec(context.Background(), q, id)
+	return err
+}
+
+// MarkOutboxFailed schedules the next attempt. A dead-lettered message loses
+// its bodies for the same reason as a sent one; it stays listed so admins can
+// see the failure.
+func (o *OutboxRepo) MarkOutboxFailed(id int64, attempts int, nextAttemptAt time.Time, lastError string, dead bool) error {
+	status := domain.OutboxStatusPending
+	if dead {
+		status = domain.OutboxStatusDead
+	}
+
+	q := `UPDATE outbox SET status = $1, attempts = $2, next_attempt_at = $3, last_error = $4, locked_until = NULL,
+		body_text = CASE WHEN $1 = 'dead' THEN NULL ELSE body_text END,
+		body_html = CASE WHEN $1 = 'dead' THEN NULL ELSE body_html END
+		WHERE id = $5`
+	_, err := o.db.Exec(context.Background(), q, status, attempts, nextAttemptAt, lastError, id)
+	return err
+}
+
+func (o *OutboxRepo) SelectOutbox(status string, limits int64, page int64) ([]domain.OutboxMessage, error) {
+	offset := (page - 1) * limits
+	q := `SELECT ` + outboxColumns + ` FROM outbox WHERE ($1 = '' OR status = $1) ORDER BY id DESC LIMIT $2 OFFSET $3`
+
+	rows, err := o.db.Query(context.Background(), q, status, limits, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []domain.OutboxMessage{}
+	for rows.Next() {
+		msg, err := scanOutbox(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// RetryOutbox only requeues messages that still have their content. Sent and
+// dead messages are redacted, so the user has to ask for a new code or link.
+func (o *OutboxRepo) RetryOutbox(id int64) error {
+	q := `
+		UPDATE outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW(), locked_until = NULL
+		WHERE id = $1 AND status = 'pending' AND (body_text IS NOT NULL OR body_html IS NOT NULL)
+	`
+	tag, err := o.db.Exec(context.Background(), q, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = o.db.QueryRow(context.Background(), `SELECT EXISTS(SELECT 1 FROM outbox WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrOutboxNotFound
+	}
+	return domain.ErrOutboxNotRetryable
+}
@@ -0,0 +1,183 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gosmol/internal/domain"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// lockGrading locks the diploma row for the rest of the transaction and
+// fails if its grading was finalized, so a review cannot slip in while the
+// grade is being fixed.
+func lockGrading(ctx context.Context, tx pgx.Tx, diplomaID int64) error {
+	var finalizedAt *time.Time
+	err := tx.QueryRow(ctx,
+		`SELECT grading_finalized_at FROM diplomas WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, diplomaID).
+		Scan(&finalizedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.ErrDiplomaNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if finalizedAt != nil {
+		return domain.ErrGradingFinalized
+	}
+	return nil
+}
+
+// UpsertReview stores the reviewer's review of a diploma, replacing their
+// previous one together with its scores.
+func (d *DiplomasRepo) UpsertReview(review domain.Review) (int64, error) {
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockGrading(ctx, tx, review.DiplomaID); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO diploma_reviews (diploma_id, reviewer_id, grade, comment, weighted_score)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT (diploma_id, reviewer_id) DO UPDATE SET
+			grade = EXCLUDED.grade,
+			comment = EXCLUDED.comment,
+			weighted_score = EXCLUDED.weighted_score,
+			updated_at = NOW()
+		RETURNING id`,
+		review.DiplomaID, review.ReviewerID, review.Grade, review.Comment, review.WeightedScore).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM diploma_review_scores WHERE review_id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, score := range review.Scores {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO diploma_review_scores (review_id, criterion, score, comment)
+			VALUES ($1, $2, $3, NULLIF($4, ''))`, id, score.Criterion, score.Score, score.Comment)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fmt.Printf("DEBUG REVIEWS: Review %d of diploma %d saved by user %d\n", id, review.DiplomaID, review.ReviewerID)
+	return id, tx.Commit(ctx)
+}
+
+func (d *DiplomasRepo) SelectReviews(diplomaID int64) ([]domain.Review, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT id, diploma_id, reviewer_id, grade, COALESCE(comment, ''), weighted_score::float8, created_at, updated_at
+		FROM diploma_reviews
+		WHERE diploma_id = $1
+		ORDER BY created_at, id`, diplomaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []domain.Review{}
+	index := make(map[int64]int)
+	for rows.Next() {
+		var review domain.Review
+		err := rows.Scan(&review.ID, &review.DiplomaID, &review.ReviewerID, &review.Grade, &review.Comment,
+			&review.WeightedScore, &review.CreatedAt, &review.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		review.Scores = []domain.CriterionScore{}
+		index[review.ID] = len(reviews)
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	scores, err := d.db.Query(ctx, `
+		SELECT s.review_id, s.criterion, s.score, COALESCE(s.comment, '')
+		FROM diploma_review_scores s
+		JOIN diploma_reviews r ON r.id = s.review_id
+		WHERE r.diploma_id = $1`, diplomaID)
+	if err != nil {
+		return nil, err
+	}
+	defer scores.Close()
+
+	for scores.Next() {
+		var reviewID int64
+		var score domain.CriterionScore
+		if err := scores.Scan(&reviewID, &score.Criterion, &score.Score, &score.Comment); err != nil {
+			return nil, err
+		}
+		if i, ok := index[reviewID]; ok {
+			reviews[i].Scores = append(reviews[i].Scores, score)
+		}
+	}
+
+	return reviews, scores.Err()
+}
+
+func (d *DiplomasRepo) SelectGrading(diplomaID int64) (*int, *time.Time, error) {
+	var grade *int
+	var finalizedAt *time.Time
+	err := d.db.QueryRow(context.Background(),
+		`SELECT final_grade, grading_finalized_at FROM diplomas WHERE id = $1 AND deleted_at IS NULL`, diplomaID).
+		Scan(&grade, &finalizedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, domain.ErrDiplomaNotFound
+	}
+	return grade, finalizedAt, err
+}
+
+// FinalizeGrading fixes the final grade as the rounded mean of the review
+// grades and locks the reviews. The grade is computed under the row lock so a
+// review saved concurrently is either counted or refused.
+func (d *DiplomasRepo) FinalizeGrading(diplomaID int64, actorID int64) (int, error) {
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockGrading(ctx, tx, diplomaID); err != nil {
+		return 0, err
+	}
+
+	var grade *int
+	err = tx.QueryRow(ctx,
+		`SELECT ROUND(AVG(grade))::int FROM diploma_reviews WHERE diploma_id = $1`, diplomaID).Scan(&grade)
+	if err != nil {
+		return 0, err
+	}
+	if grade == nil {
+		return 0, domain.ErrNoReviews
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE diplomas SET final_grade = $1, grading_finalized_at = NOW(), version = version + 1, updated_at = NOW()
+		WHERE id = $2`, *grade, diplomaID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertRevision(ctx, tx, diplomaID, domain.RevisionGrade, actorID); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("DEBUG REVIEWS: Grading of diploma %d finalized with grade %d\n", diplomaID, *grade)
+	return *grade, tx.Commit(ctx)
+}
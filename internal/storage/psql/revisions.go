@@ -0,0 +1,62 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"gosmol/internal/domain"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// insertRevision snapshots the diploma as it is inside tx, so the revision
+// always matches what the change wrote.
+func insertRevision(ctx context.Context, tx pgx.Tx, diplomaID int64, action string, authorID int64) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO diploma_revisions (diploma_id, version, action, title, description, status, author_id)
+		SELECT id, version, $2, title, description, status, NULLIF($3, 0) FROM diplomas WHERE id = $1`,
+		diplomaID, action, authorID)
+	return err
+}
+
+const revisionColumns = `
+	id, diploma_id, version, action, title, COALESCE(description, ''), status, COALESCE(author_id, 0), created_at
+	FROM diploma_revisions`
+
+func scanRevision(row pgx.Row) (domain.DiplomaRevision, error) {
+	var revision domain.DiplomaRevision
+	err := row.Scan(&revision.ID, &revision.DiplomaID, &revision.Version, &revision.Action, &revision.Title,
+		&revision.Description, &revision.Status, &revision.AuthorID, &revision.CreatedAt)
+	return revision, err
+}
+
+func (d *DiplomasRepo) SelectRevisions(diplomaID int64) ([]domain.DiplomaRevision, error) {
+	rows, err := d.db.Query(context.Background(),
+		"SELECT "+revisionColumns+" WHERE diploma_id = $1 ORDER BY version", diplomaID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []domain.DiplomaRevision{}
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, rows.Err()
+}
+
+// SelectRevision returns the revision that produced the given version, or
+// the latest one when version is 0.
+func (d *DiplomasRepo) SelectRevision(diplomaID int64, version int64) (domain.DiplomaRevision, error) {
+	revision, err := scanRevision(d.db.QueryRow(context.Background(),
+		"SELECT "+revisionColumns+` WHERE diploma_id = $1 AND ($2 = 0 OR version = $2)
+		ORDER BY version DESC LIMIT 1`, diplomaID, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.DiplomaRevision{}, domain.ErrRevisionNotFound
+	}
+	return revision, err
+}
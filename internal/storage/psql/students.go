@@ -3,10 +3,11 @@ package psql
 import (
 	"context"
 	"errors"
+	"fmt"
 	"gosmol/internal/domain"
 	"time"
-	"fmt"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -19,78 +20,237 @@ func NewStudentsRepo(db *pgxpool.Pool) *StudentsRepo {
 }
 
 func (s *StudentsRepo) InsertStudents(students domain.Student) (int64, error) {
-    var id int64
-    fmt.Printf("DEBUG INSERT: Starting insert - Firstname: %s, Email: %s\n", 
-        students.Firstname, students.Email)
-    
-    query := `INSERT INTO users (firstname, lastname, email, password_hash) VALUES ($1, $2, $3, $4) RETURNING id`
-    
-    fmt.Printf("DEBUG INSERT: Executing query: %s\n", query)
-    fmt.Printf("DEBUG INSERT: Params: %s, %s, %s, [hash]\n", 
-        students.Firstname, students.Lastname, students.Email)
-    
-    err := s.db.QueryRow(context.Background(), query,
-        students.Firstname, students.Lastname, students.Email, students.PasswordHash).
-        Scan(&id)
-    
-    if err != nil {
-        fmt.Printf("DEBUG INSERT: ERROR: %v\n", err)
-        return 0, err
-    }
-    
-    fmt.Printf("DEBUG INSERT: SUCCESS - Inserted with ID: %d\n", id)
-    return id, nil
+	var id int64
+	fmt.Printf("DEBUG INSERT: Starting insert - Firstname: %s, Email: %s\n",
+		students.Firstname, students.Email)
+
+	query := `INSERT INTO users (firstname, lastname, email, password_hash) VALUES ($1, $2, $3, $4) RETURNING id`
+
+	fmt.Printf("DEBUG INSERT: Executing query: %s\n", query)
+	fmt.Printf("DEBUG INSERT: Params: %s, %s, %s, [hash]\n",
+		students.Firstname, students.Lastname, students.Email)
+
+	err := s.db.QueryRow(context.Background(), query,
+		students.Firstname, students.Lastname, students.Email, students.PasswordHash).
+		Scan(&id)
+
+	if err != nil {
+		fmt.Printf("DEBUG INSERT: ERROR: %v\n", err)
+		return 0, err
+	}
+
+	fmt.Printf("DEBUG INSERT: SUCCESS - Inserted with ID: %d\n", id)
+	return id, nil
 }
 
 func (s *StudentsRepo) SelectStudents(email string) (domain.Student, error) {
-    var stud domain.Student
-    fmt.Printf("DEBUG SELECT: Searching user with email: %s\n", email)
-    
-    query := `SELECT id, firstname, lastname, email, password_hash, created_at, two_fa_enabled FROM users WHERE email = $1`
-    
-    err := s.db.QueryRow(context.Background(), query, email).
-        Scan(&stud.ID, &stud.Firstname, &stud.Lastname, &stud.Email, &stud.PasswordHash, &stud.CreatedAt, &stud.TwoFAEnabled)
-    
-    if err != nil {
-        fmt.Printf("DEBUG SELECT: ERROR: %v\n", err)
-        return stud, err
-    }
-    
-    fmt.Printf("DEBUG SELECT: SUCCESS - User ID: %d, Email: %s\n", stud.ID, stud.Email)
-    return stud, nil
-}
-
-func (s *StudentsRepo) RefreshStore(userID int64, token string, expiresAt time.Time) error {
-	_, err := s.db.Exec(context.Background(), 
-		"INSERT INTO refresh_token (user_id, token, expires_at) VALUES ($1, $2, $3)", 
-		userID, token, expiresAt)
-	
-	return err
+	var stud domain.Student
+	fmt.Printf("DEBUG SELECT: Searching user with email: %s\n", email)
+
+	query := `SELECT id, firstname, lastname, email, password_hash, created_at, two_fa_enabled, two_fa_method, email_verified, role FROM users WHERE email = $1`
+
+	err := s.db.QueryRow(context.Background(), query, email).
+		Scan(&stud.ID, &stud.Firstname, &stud.Lastname, &stud.Email, &stud.PasswordHash, &stud.CreatedAt, &stud.TwoFAEnabled, &stud.TwoFAMethod, &stud.EmailVerified, &stud.Role)
+
+	if err != nil {
+		fmt.Printf("DEBUG SELECT: ERROR: %v\n", err)
+		return stud, err
+	}
+
+	fmt.Printf("DEBUG SELECT: SUCCESS - User ID: %d, Email: %s\n", stud.ID, stud.Email)
+	return stud, nil
 }
 
-func (s *StudentsRepo) RefreshGet(token string) (int64, error) {
-	var userID int64
-	var expiresAt time.Time
-	err := s.db.QueryRow(context.Background(), 
-		"SELECT user_id, expires_at FROM refresh_token WHERE token = $1", token).
-		Scan(&userID, &expiresAt)
+// RefreshStore opens a session and stores the first refresh token of its
+// family in one transaction.
+func (s *StudentsRepo) RefreshStore(userID int64, tokenHash string, sessionID string, client domain.ClientInfo, expiresAt time.Time) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
 
+	_, err = tx.Exec(ctx,
+		"INSERT INTO sessions (id, user_id, user_agent, ip) VALUES ($1, $2, $3, $4)",
+		sessionID, userID, client.UserAgent, client.IP)
 	if err != nil {
-		return 0, err
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO refresh_token (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, tokenHash, sessionID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RefreshRotate swaps the presented token for its successor in one
+// transaction and returns the owner and session of the token. Presenting a
+// token that was already rotated out means it leaked, so the whole family and
+// its session are revoked and a security event is recorded.
+func (s *StudentsRepo) RefreshRotate(oldHash string, newHash string, client domain.ClientInfo, expiresAt time.Time) (int64, string, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var id, userID int64
+	var familyID string
+	var tokenExpiresAt time.Time
+	var usedAt, revokedAt, sessionRevokedAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT rt.id, rt.user_id, rt.family_id, rt.expires_at, rt.used_at, rt.revoked_at, s.revoked_at
+		FROM refresh_token rt
+		JOIN sessions s ON s.id = rt.family_id
+		WHERE rt.token_hash = $1
+		FOR UPDATE OF rt, s`, oldHash).
+		Scan(&id, &userID, &familyID, &tokenExpiresAt, &usedAt, &revokedAt, &sessionRevokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, "", domain.ErrRefreshTokenInvalid
+		}
+		return 0, "", err
+	}
+
+	if revokedAt != nil || sessionRevokedAt != nil || time.Now().After(tokenExpiresAt) {
+		return 0, "", domain.ErrRefreshTokenInvalid
+	}
+
+	if usedAt != nil {
+		if err := revokeSessions(ctx, tx, `id = $1`, familyID); err != nil {
+			return 0, "", err
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO security_events (user_id, event, details) VALUES ($1, $2, $3)`,
+			userID, "refresh_token_reuse", fmt.Sprintf("family %s revoked after reuse of token %d", familyID, id))
+		if err != nil {
+			return 0, "", err
+		}
+
+		if err := tx.Commit(ctx); err != nil {
+			return 0, "", err
+		}
+		return userID, familyID, domain.ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE refresh_token SET used_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return 0, "", err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO refresh_token (user_id, token_hash, family_id, parent_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)`, userID, newHash, familyID, id, expiresAt)
+	if err != nil {
+		return 0, "", err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE sessions SET last_used_at = NOW(), ip = $2, user_agent = $3 WHERE id = $1`,
+		familyID, client.IP, client.UserAgent)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return userID, familyID, tx.Commit(ctx)
+}
+
+func (s *StudentsRepo) SelectSessions(userID int64) ([]domain.Session, error) {
+	q := `
+		SELECT id, COALESCE(user_agent, ''), COALESCE(ip, ''), created_at, last_used_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL
+		AND EXISTS (SELECT 1 FROM refresh_token rt WHERE rt.family_id = sessions.id AND rt.expires_at > NOW())
+		ORDER BY last_used_at DESC
+	`
+	rows, err := s.db.Query(context.Background(), q, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []domain.Session{}
+	for rows.Next() {
+		var session domain.Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// SessionActive reports whether access tokens of the session are still
+// accepted.
+func (s *StudentsRepo) SessionActive(sessionID string) (bool, error) {
+	var active bool
+	q := `SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL)`
+	err := s.db.QueryRow(context.Background(), q, sessionID).Scan(&active)
+	return active, err
+}
+
+// RevokeSession ends one session of the user. Sessions of other users are
+// reported as not found.
+func (s *StudentsRepo) RevokeSession(userID int64, sessionID string) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrSessionNotFound
 	}
 
-	if time.Now().After(expiresAt) {
-		s.RefreshDelete(token)
-		return 0, errors.New("token expired")
+	_, err = tx.Exec(ctx,
+		`UPDATE refresh_token SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, sessionID)
+	if err != nil {
+		return err
 	}
 
-	return userID, nil
+	return tx.Commit(ctx)
 }
 
-func (s *StudentsRepo) RefreshDelete(token string) error {
-	_, err := s.db.Exec(context.Background(), 
-		"DELETE FROM refresh_token WHERE token = $1", token)
+func (s *StudentsRepo) RevokeAllSessions(userID int64) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := revokeSessions(ctx, tx, `user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
 
+// revokeSessions marks the sessions matching where, and their refresh tokens,
+// as revoked.
+func revokeSessions(ctx context.Context, tx pgx.Tx, where string, arg interface{}) error {
+	_, err := tx.Exec(ctx, `
+		WITH revoked AS (
+			UPDATE sessions SET revoked_at = NOW()
+			WHERE `+where+` AND revoked_at IS NULL
+			RETURNING id
+		)
+		UPDATE refresh_token SET revoked_at = NOW()
+		WHERE family_id IN (SELECT id FROM revoked) AND revoked_at IS NULL`, arg)
 	return err
 }
 
@@ -110,7 +270,7 @@ func (s *StudentsRepo) StudentBlocked(email string, windowStart time.Time) ([]ma
 		{"blocked_until": blockedUntil},
 	}
 
-	return result, nil 
+	return result, nil
 }
 
 func (s *StudentsRepo) LogAttempt(email string, result bool, attemptTime time.Time) error {
@@ -131,26 +291,214 @@ func (s *StudentsRepo) GetFailedLogAttempts(email string, windowStart time.Time)
 func (s *StudentsRepo) BlockStudent(email, blockedUntil string) error {
 	q := `UPDATE login_attempts SET blocked_until = $2 WHERE email = $1 AND blocked_until IS NULL`
 	_, err := s.db.Exec(context.Background(), q, email, blockedUntil)
-	
+
 	return err
 }
 
 func (s *StudentsRepo) SelectStudentsByID(id int64) (domain.Student, error) {
-    var stud domain.Student
-    query := `SELECT id, firstname, lastname, email, password_hash, created_at, two_fa_enabled FROM users WHERE id = $1`
-    
-    err := s.db.QueryRow(context.Background(), query, id).
-        Scan(&stud.ID, &stud.Firstname, &stud.Lastname, &stud.Email, &stud.PasswordHash, &stud.CreatedAt, &stud.TwoFAEnabled)
-    
-    if err != nil {
-        return stud, err
-    }
-    
-    return stud, nil
+	var stud domain.Student
+	query := `SELECT id, firstname, lastname, email, password_hash, created_at, two_fa_enabled, two_fa_method, email_verified, role FROM users WHERE id = $1`
+
+	err := s.db.QueryRow(context.Background(), query, id).
+		Scan(&stud.ID, &stud.Firstname, &stud.Lastname, &stud.Email, &stud.PasswordHash, &stud.CreatedAt, &stud.TwoFAEnabled, &stud.TwoFAMethod, &stud.EmailVerified, &stud.Role)
+
+	if err != nil {
+		return stud, err
+	}
+
+	return stud, nil
 }
 
 func (s *StudentsRepo) RenovationTwoFAStatus(userID int64, enabled bool) error {
-    query := `UPDATE users SET two_fa_enabled = $1 WHERE id = $2`
-    _, err := s.db.Exec(context.Background(), query, enabled, userID)
-    return err
-}
\ No newline at end of file
+	query := `UPDATE users SET two_fa_enabled = $1 WHERE id = $2`
+	_, err := s.db.Exec(context.Background(), query, enabled, userID)
+	return err
+}
+
+func (s *StudentsRepo) RenovationTwoFAMethod(userID int64, method string) error {
+	query := `UPDATE users SET two_fa_method = $1 WHERE id = $2`
+	_, err := s.db.Exec(context.Background(), query, method, userID)
+	return err
+}
+
+func (s *StudentsRepo) RenovationRole(userID int64, role string) error {
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+	tag, err := s.db.Exec(context.Background(), query, role, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func (s *StudentsRepo) InsertPasswordReset(userID int64, tokenHash string, expiresAt time.Time, notification domain.OutboxMessage) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, q, userID, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutbox(ctx, tx, notification)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *StudentsRepo) SelectRecentPasswordResets(userID int64, since time.Time) (int, error) {
+	q := `SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1 AND created_at > $2`
+	var count int
+	err := s.db.QueryRow(context.Background(), q, userID, since).Scan(&count)
+	return count, err
+}
+
+// ResetPassword consumes the reset token, stores the new hash and revokes
+// every refresh token of the user in a single transaction.
+func (s *StudentsRepo) ResetPassword(tokenHash string, passwordHash string) (int64, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	q := `
+		SELECT user_id FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, q, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, errors.New("invalid or expired reset token")
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := revokeSessions(ctx, tx, `user_id = $1`, userID); err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+// InsertStudentWithVerification creates the user, their verification token
+// and the outbox message that delivers it in one transaction.
+func (s *StudentsRepo) InsertStudentWithVerification(student domain.Student, tokenHash string, expiresAt time.Time, notification domain.OutboxMessage) (int64, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO users (firstname, lastname, email, password_hash) VALUES ($1, $2, $3, $4) RETURNING id`,
+		student.Firstname, student.Lastname, student.Email, student.PasswordHash).Scan(&id)
+	if err != nil {
+		fmt.Printf("DEBUG INSERT: ERROR: %v\n", err)
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO email_verification_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		id, tokenHash, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertOutbox(ctx, tx, notification); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("DEBUG INSERT: SUCCESS - Inserted with ID: %d\n", id)
+	return id, tx.Commit(ctx)
+}
+
+func (s *StudentsRepo) InsertEmailVerification(userID int64, tokenHash string, expiresAt time.Time, notification domain.OutboxMessage) error {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	q := `INSERT INTO email_verification_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	_, err = tx.Exec(ctx, q, userID, tokenHash, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutbox(ctx, tx, notification)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *StudentsRepo) SelectRecentEmailVerifications(userID int64, since time.Time) (int, error) {
+	q := `SELECT COUNT(*) FROM email_verification_tokens WHERE user_id = $1 AND created_at > $2`
+	var count int
+	err := s.db.QueryRow(context.Background(), q, userID, since).Scan(&count)
+	return count, err
+}
+
+func (s *StudentsRepo) VerifyEmail(tokenHash string) (int64, error) {
+	ctx := context.Background()
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	q := `
+		SELECT user_id FROM email_verification_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`
+	err = tx.QueryRow(ctx, q, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, errors.New("invalid or expired verification token")
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE email_verification_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE users SET email_verified = true WHERE id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
@@ -0,0 +1,228 @@
+package psql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gosmol/internal/domain"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// supervisorColumns selects a supervisor with the number of diplomas they
+// currently supervise. Defended and deleted diplomas free their place.
+const supervisorColumns = `
+	u.id, u.firstname, u.lastname, u.email, u.supervisor_capacity,
+	(SELECT COUNT(*) FROM diplomas d WHERE d.supervisor_id = u.id AND d.status <> 'defended' AND d.deleted_at IS NULL)
+	FROM users u`
+
+func scanSupervisor(row pgx.Row) (domain.Supervisor, error) {
+	var supervisor domain.Supervisor
+	err := row.Scan(&supervisor.ID, &supervisor.Firstname, &supervisor.Lastname, &supervisor.Email,
+		&supervisor.Capacity, &supervisor.Assigned)
+	return supervisor, err
+}
+
+func (d *DiplomasRepo) SelectSupervisors() ([]domain.Supervisor, error) {
+	rows, err := d.db.Query(context.Background(),
+		"SELECT "+supervisorColumns+" WHERE u.role = $1 ORDER BY u.lastname, u.firstname", domain.RoleSupervisor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	supervisors := []domain.Supervisor{}
+	for rows.Next() {
+		supervisor, err := scanSupervisor(rows)
+		if err != nil {
+			return nil, err
+		}
+		supervisors = append(supervisors, supervisor)
+	}
+
+	return supervisors, rows.Err()
+}
+
+func (d *DiplomasRepo) SelectSupervisor(id int64) (domain.Supervisor, error) {
+	supervisor, err := scanSupervisor(d.db.QueryRow(context.Background(),
+		"SELECT "+supervisorColumns+" WHERE u.id = $1 AND u.role = $2", id, domain.RoleSupervisor))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Supervisor{}, domain.ErrSupervisorNotFound
+	}
+	return supervisor, err
+}
+
+func (d *DiplomasRepo) RenovationSupervisorCapacity(id int64, capacity int) error {
+	tag, err := d.db.Exec(context.Background(),
+		`UPDATE users SET supervisor_capacity = $1 WHERE id = $2 AND role = $3`, capacity, id, domain.RoleSupervisor)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrSupervisorNotFound
+	}
+	return nil
+}
+
+func (d *DiplomasRepo) SelectResourcesBySupervisor(supervisorID int64) ([]domain.Diploma, error) {
+	rows, err := d.db.Query(context.Background(),
+		"SELECT "+diplomaColumns+diplomaTables+" WHERE d.supervisor_id = $1 AND d.deleted_at IS NULL ORDER BY d.id", supervisorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diplomas := []domain.Diploma{}
+	for rows.Next() {
+		diploma, err := scanDiploma(rows)
+		if err != nil {
+			return nil, err
+		}
+		diplomas = append(diplomas, diploma)
+	}
+
+	return diplomas, rows.Err()
+}
+
+// InsertSupervisionRequest stores a pending request unless the diploma
+// already has one waiting.
+func (d *DiplomasRepo) InsertSupervisionRequest(request domain.SupervisionRequest) (int64, error) {
+	var id int64
+	err := d.db.QueryRow(context.Background(), `
+		INSERT INTO supervision_requests (diploma_id, student_id, supervisor_id, message)
+		SELECT $1, $2, $3, NULLIF($4, '')
+		WHERE NOT EXISTS (
+			SELECT 1 FROM supervision_requests WHERE diploma_id = $1 AND status = 'pending'
+		)
+		RETURNING id`,
+		request.DiplomaID, request.StudentID, request.SupervisorID, request.Message).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, domain.ErrRequestPending
+	}
+	return id, err
+}
+
+const supervisionRequestColumns = `
+	r.id, r.diploma_id, d.title, r.student_id, r.supervisor_id, r.status,
+	COALESCE(r.message, ''), COALESCE(r.reason, ''), r.created_at, r.decided_at
+	FROM supervision_requests r
+	JOIN diplomas d ON d.id = r.diploma_id AND d.deleted_at IS NULL`
+
+func scanSupervisionRequest(row pgx.Row) (domain.SupervisionRequest, error) {
+	var request domain.SupervisionRequest
+	err := row.Scan(&request.ID, &request.DiplomaID, &request.DiplomaTitle, &request.StudentID,
+		&request.SupervisorID, &request.Status, &request.Message, &request.Reason,
+		&request.CreatedAt, &request.DecidedAt)
+	return request, err
+}
+
+func (d *DiplomasRepo) SelectSupervisionRequest(id int64) (domain.SupervisionRequest, error) {
+	request, err := scanSupervisionRequest(d.db.QueryRow(context.Background(),
+		"SELECT "+supervisionRequestColumns+" WHERE r.id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.SupervisionRequest{}, domain.ErrRequestNotFound
+	}
+	return request, err
+}
+
+// SelectSupervisionRequests lists the requests sent to a supervisor, all of
+// them when status is empty.
+func (d *DiplomasRepo) SelectSupervisionRequests(supervisorID int64, status string) ([]domain.SupervisionRequest, error) {
+	rows, err := d.db.Query(context.Background(),
+		"SELECT "+supervisionRequestColumns+` WHERE r.supervisor_id = $1 AND ($2 = '' OR r.status = $2)
+		ORDER BY r.created_at DESC`, supervisorID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []domain.SupervisionRequest{}
+	for rows.Next() {
+		request, err := scanSupervisionRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+
+	return requests, rows.Err()
+}
+
+// AcceptSupervisionRequest assigns the supervisor to the diploma. The
+// supervisor row is locked while the load is counted, so parallel accepts
+// cannot push them over capacity.
+func (d *DiplomasRepo) AcceptSupervisionRequest(id int64) error {
+	ctx := context.Background()
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var diplomaID, supervisorID int64
+	var status string
+	err = tx.QueryRow(ctx,
+		`SELECT diploma_id, supervisor_id, status FROM supervision_requests WHERE id = $1 FOR UPDATE`, id).
+		Scan(&diplomaID, &supervisorID, &status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.ErrRequestNotFound
+		}
+		return err
+	}
+	if status != domain.SupervisionPending {
+		return domain.ErrRequestDecided
+	}
+
+	var capacity, assigned int
+	err = tx.QueryRow(ctx, `SELECT supervisor_capacity FROM users WHERE id = $1 FOR UPDATE`, supervisorID).
+		Scan(&capacity)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*) FROM diplomas WHERE supervisor_id = $1 AND status <> 'defended' AND deleted_at IS NULL`, supervisorID).
+		Scan(&assigned)
+	if err != nil {
+		return err
+	}
+	if assigned >= capacity {
+		return domain.ErrCapacityReached
+	}
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE diplomas SET supervisor_id = $1, version = version + 1, updated_at = NOW() WHERE id = $2 AND supervisor_id IS NULL AND deleted_at IS NULL`,
+		supervisorID, diplomaID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrAlreadySupervised
+	}
+
+	if err := insertRevision(ctx, tx, diplomaID, domain.RevisionSupervise, supervisorID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE supervision_requests SET status = $1, decided_at = NOW() WHERE id = $2`, domain.SupervisionAccepted, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("DEBUG SUPERVISION: Supervisor %d assigned to diploma %d (%d/%d)\n", supervisorID, diplomaID, assigned+1, capacity)
+	return tx.Commit(ctx)
+}
+
+func (d *DiplomasRepo) DeclineSupervisionRequest(id int64, reason string) error {
+	tag, err := d.db.Exec(context.Background(), `
+		UPDATE supervision_requests SET status = $1, reason = NULLIF($2, ''), decided_at = NOW()
+		WHERE id = $3 AND status = $4`, domain.SupervisionDeclined, reason, id, domain.SupervisionPending)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrRequestDecided
+	}
+	return nil
+}
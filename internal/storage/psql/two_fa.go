@@ -2,6 +2,7 @@ package psql
 
 import (
 	"context"
+	"errors"
 	"gosmol/internal/domain"
 	"time"
 
@@ -16,10 +17,26 @@ func NewTwoFaRepo(db *pgxpool.Pool) *TwoFaRepo {
 	return &TwoFaRepo{db: db}
 }
 
-func (t *TwoFaRepo) InsertTwoFaCode(userID int64, code string, expiresAt time.Time) error {
+func (t *TwoFaRepo) InsertTwoFaCode(userID int64, code string, expiresAt time.Time, notification domain.OutboxMessage) error {
+	ctx := context.Background()
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
 	q := `INSERT INTO two_fa_codes (user_id, code, expires_at) VALUES ($1, $2, $3)`
-	_, err := t.db.Exec(context.Background(), q, userID, code, expiresAt)
-	return err
+	_, err = tx.Exec(ctx, q, userID, code, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutbox(ctx, tx, notification)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
 func (t *TwoFaRepo) SelectTwoFaCodeByUserID(userID int64) (domain.TwoFaCode, error) {
@@ -66,4 +83,100 @@ func (t *TwoFaRepo) SelectRecentVerificationAttempts(userID int64, since time.Ti
 	var count int
 	err := t.db.QueryRow(context.Background(), q, userID, since).Scan(&count)
 	return count, err
-}
\ No newline at end of file
+}
+
+func (t *TwoFaRepo) UpsertTotpSecret(userID int64, secret string) error {
+	q := `
+		INSERT INTO two_fa_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, confirmed = false, last_used_step = 0, attempts = 0, created_at = NOW()
+	`
+	_, err := t.db.Exec(context.Background(), q, userID, secret)
+	return err
+}
+
+func (t *TwoFaRepo) SelectTotpSecret(userID int64) (domain.TotpSecret, error) {
+	var totp domain.TotpSecret
+	q := `
+		SELECT user_id, secret, confirmed, last_used_step, attempts,
+			COALESCE(last_attempt_at, created_at), created_at
+		FROM two_fa_totp
+		WHERE user_id = $1
+	`
+
+	err := t.db.QueryRow(context.Background(), q, userID).
+		Scan(&totp.UserID, &totp.Secret, &totp.Confirmed, &totp.LastUsedStep,
+			&totp.Attempts, &totp.LastAttemptAt, &totp.CreatedAt)
+
+	return totp, err
+}
+
+func (t *TwoFaRepo) ConfirmTotpSecret(userID int64, step int64) error {
+	q := `UPDATE two_fa_totp SET confirmed = true, last_used_step = $1, attempts = 0 WHERE user_id = $2`
+	_, err := t.db.Exec(context.Background(), q, step, userID)
+	return err
+}
+
+func (t *TwoFaRepo) RenovationTotpAttempts(userID int64, attempts int) error {
+	q := `UPDATE two_fa_totp SET attempts = $1, last_attempt_at = NOW() WHERE user_id = $2`
+	_, err := t.db.Exec(context.Background(), q, attempts, userID)
+	return err
+}
+
+func (t *TwoFaRepo) MarkTotpStepUsed(userID int64, step int64) error {
+	q := `UPDATE two_fa_totp SET last_used_step = $1, attempts = 0 WHERE user_id = $2 AND last_used_step < $1`
+	tag, err := t.db.Exec(context.Background(), q, step, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("code already used")
+	}
+	return nil
+}
+
+func (t *TwoFaRepo) DestroyTotpSecret(userID int64) error {
+	q := `DELETE FROM two_fa_totp WHERE user_id = $1`
+	_, err := t.db.Exec(context.Background(), q, userID)
+	return err
+}
+
+func (t *TwoFaRepo) ReplaceRecoveryCodes(userID int64, hashes []string) error {
+	ctx := context.Background()
+	tx, err := t.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM two_fa_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO two_fa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (t *TwoFaRepo) UseRecoveryCode(userID int64, hash string) (bool, error) {
+	q := `UPDATE two_fa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := t.db.Exec(context.Background(), q, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (t *TwoFaRepo) CountRecoveryCodes(userID int64) (int, error) {
+	q := `SELECT COUNT(*) FROM two_fa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	var count int
+	err := t.db.QueryRow(context.Background(), q, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Token types carried in the "typ" claim. A token is only accepted where its
+// type is expected, so a 2FA temp token can never act as an access token.
+const (
+	TokenAccess  = "access"
+	TokenTemp    = "temp"
+	TokenRefresh = "refresh"
+)
+
+type Claims struct {
+	UserID    int64  `json:"user_id"`
+	Type      string `json:"typ"`
+	SessionID string `json:"sid,omitempty"`
+	Role      string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func NewClaims(userID int64, tokenType, issuer, audience string, ttl time.Duration) Claims {
+	now := time.Now()
+	return Claims{
+		UserID: userID,
+		Type:   tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenID(),
+			Subject:   strconv.FormatInt(userID, 10),
+			Issuer:    issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+}
+
+// Verify parses tokenString and checks signature, expiry, issuer, audience
+// and token type.
+func (k *KeySet) Verify(tokenString, tokenType, issuer, audience string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := k.Parse(tokenString, claims,
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Type != tokenType {
+		return nil, fmt.Errorf("expected %s token, got %q", tokenType, claims.Type)
+	}
+
+	if claims.UserID == 0 || claims.ID == "" {
+		return nil, errors.New("token is missing user_id or jti")
+	}
+
+	if tokenType == TokenAccess && claims.SessionID == "" {
+		return nil, errors.New("access token is missing sid")
+	}
+
+	return claims, nil
+}
+
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,340 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+
+	privateKeySuffix = ".pem"
+	publicKeySuffix  = ".pub.pem"
+)
+
+type verificationKey struct {
+	kid       string
+	algorithm string
+	public    crypto.PublicKey
+	private   crypto.Signer
+	createdAt time.Time
+}
+
+// KeySet holds the asymmetric keys used to sign and verify JWTs. Keys live in
+// a directory as PEM files named "<kid>.pem" (PKCS#8 private key) or
+// "<kid>.pub.pem" (PKIX public key, verification only). The newest private key
+// of the configured algorithm signs new tokens, every other key keeps
+// verifying until its retention period after retirement runs out.
+type KeySet struct {
+	mu        sync.RWMutex
+	dir       string
+	algorithm string
+	retention time.Duration
+	keys      map[string]*verificationKey
+	active    *verificationKey
+}
+
+func LoadKeySet(dir, algorithm string, retention time.Duration) (*KeySet, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	k := &KeySet{dir: dir, algorithm: algorithm, retention: retention}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	if k.active == nil {
+		if _, err := k.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
+
+// Reload rescans the key directory so keys rotated by another instance
+// sharing the directory become known.
+func (k *KeySet) Reload() error {
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*verificationKey)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, privateKeySuffix) {
+			continue
+		}
+
+		key, err := readKeyFile(filepath.Join(k.dir, name))
+		if err != nil {
+			return fmt.Errorf("load key %s: %w", name, err)
+		}
+		keys[key.kid] = key
+	}
+
+	var active *verificationKey
+	for _, key := range keys {
+		if key.private == nil || key.algorithm != k.algorithm {
+			continue
+		}
+		if active == nil || key.createdAt.After(active.createdAt) {
+			active = key
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.active = active
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Rotate generates a new signing key, persists it and makes it active.
+// Keys retired longer than the retention period are removed.
+func (k *KeySet) Rotate() (string, error) {
+	key, err := generateKey(k.algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key.private)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(k.dir, key.kid+privateKeySuffix)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	if k.keys == nil {
+		k.keys = make(map[string]*verificationKey)
+	}
+	k.keys[key.kid] = key
+	k.active = key
+	k.mu.Unlock()
+
+	k.prune()
+	return key.kid, nil
+}
+
+// RunRotation rotates the signing key once it is older than interval and
+// picks up keys written by other instances. It blocks until ctx is done.
+func (k *KeySet) RunRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	if interval < time.Hour {
+		ticker.Reset(interval)
+	}
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := k.Reload(); err != nil {
+			fmt.Printf("DEBUG KEYS: reload failed: %v\n", err)
+			continue
+		}
+
+		k.mu.RLock()
+		due := k.active == nil || time.Since(k.active.createdAt) >= interval
+		k.mu.RUnlock()
+
+		if due {
+			kid, err := k.Rotate()
+			if err != nil {
+				fmt.Printf("DEBUG KEYS: rotation failed: %v\n", err)
+				continue
+			}
+			fmt.Printf("DEBUG KEYS: rotated signing key, new kid %s\n", kid)
+		}
+	}
+}
+
+func (k *KeySet) prune() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ordered := make([]*verificationKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		ordered = append(ordered, key)
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].createdAt.Before(ordered[j].createdAt) })
+
+	for i := 0; i < len(ordered)-1; i++ {
+		key := ordered[i]
+		retiredAt := ordered[i+1].createdAt
+		if key == k.active || key.private == nil || time.Since(retiredAt) < k.retention {
+			continue
+		}
+
+		delete(k.keys, key.kid)
+		os.Remove(filepath.Join(k.dir, key.kid+privateKeySuffix))
+		os.Remove(filepath.Join(k.dir, key.kid+publicKeySuffix))
+	}
+}
+
+// Sign issues a token signed by the active key with its kid in the header.
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+
+	if active == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(signingMethod(active.algorithm), claims)
+	token.Header["kid"] = active.kid
+	return token.SignedString(active.private)
+}
+
+// Parse verifies tokenString against the key named by its kid header. Only
+// asymmetric algorithms are accepted, so an HS256 token signed with a public
+// key is rejected.
+func (k *KeySet) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) (*jwt.Token, error) {
+	opts = append(opts, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
+	return jwt.ParseWithClaims(tokenString, claims, k.keyfunc, opts...)
+}
+
+func (k *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("algorithm %s does not match key %s", token.Method.Alg(), kid)
+	}
+
+	return key.public, nil
+}
+
+func signingMethod(algorithm string) jwt.SigningMethod {
+	if algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func generateKey(algorithm string) (*verificationKey, error) {
+	kid := newKid()
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return &verificationKey{kid: kid, algorithm: algorithm, public: &private.PublicKey, private: private, createdAt: time.Now()}, nil
+	case AlgorithmEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &verificationKey{kid: kid, algorithm: algorithm, public: public, private: private, createdAt: time.Now()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+func newKid() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(b)
+}
+
+func readKeyFile(path string) (*verificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	name := filepath.Base(path)
+	key := &verificationKey{createdAt: info.ModTime()}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key.kid = strings.TrimSuffix(name, privateKeySuffix)
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("private key cannot sign")
+		}
+		key.private = signer
+		key.public = signer.Public()
+	case "PUBLIC KEY":
+		key.kid = strings.TrimSuffix(name, publicKeySuffix)
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.public = parsed
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		key.algorithm = AlgorithmRS256
+	case ed25519.PublicKey:
+		key.algorithm = AlgorithmEdDSA
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+
+	return key, nil
+}
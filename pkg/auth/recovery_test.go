@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != recoveryCodeLength+1 || code[recoveryCodeLength/2] != '-' {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		for _, r := range strings.ReplaceAll(code, "-", "") {
+			if !strings.ContainsRune(recoveryCodeAlphabet, r) {
+				t.Errorf("code %q contains %q outside the alphabet", code, r)
+			}
+		}
+		if seen[code] {
+			t.Errorf("code %q generated twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("abcde-fghjk")
+
+	tests := []struct {
+		name  string
+		input string
+		same  bool
+	}{
+		{"identical", "abcde-fghjk", true},
+		{"upper case", "ABCDE-FGHJK", true},
+		{"without dash", "abcdefghjk", true},
+		{"with spaces", " abcde fghjk ", true},
+		{"different code", "abcde-fghjm", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashRecoveryCode(tt.input) == want; got != tt.same {
+				t.Errorf("HashRecoveryCode(%q) matches = %t, want %t", tt.input, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestHashRecoveryCodeIsHexSHA256(t *testing.T) {
+	got := HashRecoveryCode("abcde-fghjk")
+	if len(got) != 64 || strings.Trim(got, "0123456789abcdef") != "" {
+		t.Errorf("hash %q is not hex SHA-256", got)
+	}
+	if strings.Contains(got, "abcde") {
+		t.Error("hash contains the code")
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890" in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TotpCode(rfc6238Secret, TotpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TotpCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TotpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTotpCodeAcceptsLowercaseSecret(t *testing.T) {
+	got, err := TotpCode(" gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := TotpCode(rfc6238Secret, 1)
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestTotpCodeInvalidSecret(t *testing.T) {
+	if _, err := TotpCode("not base32!", 1); err == nil {
+		t.Error("expected an error for an invalid secret")
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := TotpStep(now)
+	code := func(s int64) string {
+		c, err := TotpCode(rfc6238Secret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, code(step), step, true},
+		{"previous step within skew", rfc6238Secret, code(step - 1), step - 1, true},
+		{"next step within skew", rfc6238Secret, code(step + 1), step + 1, true},
+		{"two steps old", rfc6238Secret, code(step - 2), 0, false},
+		{"two steps ahead", rfc6238Secret, code(step + 2), 0, false},
+		{"too short", rfc6238Secret, "12345", 0, false},
+		{"too long", rfc6238Secret, "1234567", 0, false},
+		{"invalid secret", "not base32!", "123456", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTotp(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("ValidateTotp = (%d, %t), want (%d, %t)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTotpSecret(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not unpadded base32: %v", secret, err)
+	}
+	if len(key) != totpSecretSize {
+		t.Errorf("secret has %d bytes, want %d", len(key), totpSecretSize)
+	}
+
+	other, _ := GenerateTotpSecret()
+	if other == secret {
+		t.Error("two generated secrets are equal")
+	}
+}
+
+func TestTotpURI(t *testing.T) {
+	uri, err := url.Parse(TotpURI("gosmol", "ivan@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	if uri.Path != "/gosmol:ivan@example.com" {
+		t.Errorf("label = %q", uri.Path)
+	}
+
+	query := uri.Query()
+	want := map[string]string{
+		"secret": rfc6238Secret, "issuer": "gosmol", "algorithm": "SHA1", "digits": "6", "period": "30",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type position struct {
+	Key string `json:"k"`
+	ID  int64  `json:"i"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	want := position{Key: "2024-01-02 03:04:05", ID: 42}
+
+	token, err := signer.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token %q is not URL safe", token)
+	}
+
+	var got position
+	if err := signer.Decode(token, &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeRejectsInvalidTokens(t *testing.T) {
+	signer := NewSigner([]byte("secret"))
+	token, err := signer.Encode(position{Key: "a", ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, sig, _ := strings.Cut(token, ".")
+
+	enc := base64.RawURLEncoding
+	forged := enc.EncodeToString([]byte(`{"k":"a","i":999}`))
+	other, _ := NewSigner([]byte("other")).Encode(position{Key: "a", ID: 1})
+	notJSON := enc.EncodeToString([]byte("not json"))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", data},
+		{"edited payload", forged + "." + sig},
+		{"truncated signature", data + "." + sig[:len(sig)-2]},
+		{"signature not base64", data + ".!!!"},
+		{"payload not base64", "!!!." + sig},
+		{"signed with another key", other},
+		{"signed payload that is not json", notJSON + "." + enc.EncodeToString(signer.sign([]byte("not json")))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got position
+			if err := signer.Decode(tt.token, &got); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Decode(%q) = %v, want ErrInvalid", tt.token, err)
+			}
+		})
+	}
+}
+
+func TestRandomSignersDoNotShareCursors(t *testing.T) {
+	a, err := NewRandomSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewRandomSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := a.Encode(position{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got position
+	if err := a.Decode(token, &got); err != nil {
+		t.Errorf("issuing signer rejected its own token: %v", err)
+	}
+	if err := b.Decode(token, &got); !errors.Is(err, ErrInvalid) {
+		t.Errorf("other signer accepted the token: %v", err)
+	}
+}
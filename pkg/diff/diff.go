@@ -0,0 +1,131 @@
+package diff
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	Equal  = "equal"
+	Insert = "insert"
+	Delete = "delete"
+)
+
+// maxCells bounds the LCS table. Inputs past it are reported as a whole
+// replacement instead of being compared word by word.
+const maxCells = 4 << 20
+
+type Chunk struct {
+	Op   string
+	Text string
+}
+
+// Words compares a and b word by word using the longest common subsequence.
+// Runs of whitespace are tokens of their own, so the Equal and Delete chunks
+// concatenate back to a, the Equal and Insert chunks to b.
+func Words(a, b string) []Chunk {
+	x, y := tokenize(a), tokenize(b)
+
+	var prefix, suffix []Chunk
+	for len(x) > 0 && len(y) > 0 && x[0] == y[0] {
+		prefix = append(prefix, Chunk{Op: Equal, Text: x[0]})
+		x, y = x[1:], y[1:]
+	}
+	for len(x) > 0 && len(y) > 0 && x[len(x)-1] == y[len(y)-1] {
+		suffix = append([]Chunk{{Op: Equal, Text: x[len(x)-1]}}, suffix...)
+		x, y = x[:len(x)-1], y[:len(y)-1]
+	}
+
+	chunks := append(prefix, lcs(x, y)...)
+	return merge(append(chunks, suffix...))
+}
+
+func lcs(x, y []string) []Chunk {
+	n, m := len(x), len(y)
+	if n == 0 || m == 0 || (n+1)*(m+1) > maxCells {
+		chunks := make([]Chunk, 0, n+m)
+		for _, t := range x {
+			chunks = append(chunks, Chunk{Op: Delete, Text: t})
+		}
+		for _, t := range y {
+			chunks = append(chunks, Chunk{Op: Insert, Text: t})
+		}
+		return chunks
+	}
+
+	// table[i][j] is the LCS length of x[i:] and y[j:].
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				table[i][j] = table[i+1][j+1] + 1
+			case table[i+1][j] >= table[i][j+1]:
+				table[i][j] = table[i+1][j]
+			default:
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+
+	chunks := make([]Chunk, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			chunks = append(chunks, Chunk{Op: Equal, Text: x[i]})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			chunks = append(chunks, Chunk{Op: Delete, Text: x[i]})
+			i++
+		default:
+			chunks = append(chunks, Chunk{Op: Insert, Text: y[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		chunks = append(chunks, Chunk{Op: Delete, Text: x[i]})
+	}
+	for ; j < m; j++ {
+		chunks = append(chunks, Chunk{Op: Insert, Text: y[j]})
+	}
+	return chunks
+}
+
+// merge joins neighbouring chunks of the same kind.
+func merge(chunks []Chunk) []Chunk {
+	merged := make([]Chunk, 0, len(chunks))
+	for _, c := range chunks {
+		if last := len(merged) - 1; last >= 0 && merged[last].Op == c.Op {
+			merged[last].Text += c.Text
+			continue
+		}
+		merged = append(merged, c)
+	}
+	return merged
+}
+
+// tokenize splits s into alternating runs of whitespace and non-whitespace.
+func tokenize(s string) []string {
+	var tokens []string
+	start := 0
+	for start < len(s) {
+		r, _ := utf8.DecodeRuneInString(s[start:])
+		space := unicode.IsSpace(r)
+		end := start
+		for end < len(s) {
+			r, size := utf8.DecodeRuneInString(s[end:])
+			if unicode.IsSpace(r) != space {
+				break
+			}
+			end += size
+		}
+		tokens = append(tokens, s[start:end])
+		start = end
+	}
+	return tokens
+}
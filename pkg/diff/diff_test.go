@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Chunk
+	}{
+		{"both empty", "", "", []Chunk{}},
+		{"unchanged", "a b", "a b", []Chunk{{Equal, "a b"}}},
+		{"all inserted", "", "hello world", []Chunk{{Insert, "hello world"}}},
+		{"all deleted", "hello world", "", []Chunk{{Delete, "hello world"}}},
+		{
+			"word replaced", "the quick fox", "the slow fox",
+			[]Chunk{{Equal, "the "}, {Delete, "quick"}, {Insert, "slow"}, {Equal, " fox"}},
+		},
+		{
+			"word appended", "a", "a b",
+			[]Chunk{{Equal, "a"}, {Insert, " b"}},
+		},
+		{
+			"word removed", "one two three", "one three",
+			[]Chunk{{Equal, "one "}, {Delete, "two "}, {Equal, "three"}},
+		},
+		{
+			"whitespace changed", "a b", "a  b",
+			[]Chunk{{Equal, "a"}, {Delete, " "}, {Insert, "  "}, {Equal, "b"}},
+		},
+		{
+			"cyrillic", "Диплом по вебу", "Диплом по мобильной разработке",
+			[]Chunk{{Equal, "Диплом по "}, {Delete, "вебу"}, {Insert, "мобильной разработке"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordsReconstructsBothSides(t *testing.T) {
+	pairs := [][2]string{
+		{"a b c d e", "a x c y e"},
+		{"  leading and trailing  ", "leading\tand\ntrailing"},
+		{"repeat repeat repeat", "repeat once repeat"},
+		{"Исследование фреймворков", "Исследование современных фреймворков для веба"},
+	}
+
+	for _, pair := range pairs {
+		var a, b strings.Builder
+		for _, c := range Words(pair[0], pair[1]) {
+			if c.Op != Insert {
+				a.WriteString(c.Text)
+			}
+			if c.Op != Delete {
+				b.WriteString(c.Text)
+			}
+		}
+		if a.String() != pair[0] || b.String() != pair[1] {
+			t.Errorf("Words(%q, %q) rebuilds %q and %q", pair[0], pair[1], a.String(), b.String())
+		}
+	}
+}
+
+func TestWordsLargeInputFallsBackToReplacement(t *testing.T) {
+	body := strings.Repeat("word ", 1500)
+	a := "first " + body + "last"
+	b := "begin " + body + "end"
+
+	want := []Chunk{{Delete, a}, {Insert, b}}
+	if got := Words(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %d chunks, want a single delete and insert", len(got))
+	}
+}
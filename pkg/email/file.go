@@ -0,0 +1,37 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSender drops every message as an .eml file into a mailbox directory
+// instead of delivering it. Used for local development and tests.
+type FileSender struct {
+	dir  string
+	from string
+}
+
+func NewFileSender(dir, from string) (*FileSender, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("mailbox directory is not configured")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FileSender{dir: dir, from: from}, nil
+}
+
+func (f *FileSender) Send(msg Message) error {
+	body, err := buildMIME(f.from, msg)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d.eml", time.Now().UTC().Format("20060102T150405"), time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(f.dir, name), body, 0640)
+}
@@ -0,0 +1,133 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSenderDeliversRenderedMessage(t *testing.T) {
+	dir := t.TempDir()
+	sender, err := NewSender(Config{Driver: DriverFile, MailboxDir: dir, From: "no-reply@gosmol.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := Render(TemplateTwoFaCode, "ivan@example.com", map[string]interface{}{
+		"Name": "Иван", "Code": "654321", "TTLMinutes": 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want one .eml file in the mailbox, got %v (%v)", files, err)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	delivered, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := delivered.Header.Get("To"); got != "ivan@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := delivered.Header.Get("From"); got != "no-reply@gosmol.local" {
+		t.Errorf("From = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(delivered.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Subject)
+	}
+	if !strings.HasSuffix(delivered.Header.Get("Message-ID"), "@gosmol.local>") {
+		t.Errorf("Message-ID = %q", delivered.Header.Get("Message-ID"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(delivered.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v)", mediaType, err)
+	}
+
+	bodies := make(map[string]string)
+	parts := multipart.NewReader(delivered.Body, params["boundary"])
+	for {
+		part, err := parts.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		bodies[partType] = strings.ReplaceAll(string(body), "\r\n", "\n")
+	}
+
+	if bodies["text/plain"] != msg.Text {
+		t.Errorf("text part = %q, want %q", bodies["text/plain"], msg.Text)
+	}
+	if bodies["text/html"] != msg.HTML {
+		t.Errorf("html part = %q, want %q", bodies["text/html"], msg.HTML)
+	}
+}
+
+func TestFileSenderRejectsHeaderInjection(t *testing.T) {
+	sender, err := NewFileSender(t.TempDir(), "no-reply@gosmol.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []Message{
+		{To: "", Subject: "hi", Text: "body"},
+		{To: "ivan@example.com\r\nBcc: all@example.com", Subject: "hi", Text: "body"},
+		{To: "ivan@example.com", Subject: "hi\nBcc: all@example.com", Text: "body"},
+	}
+	for _, msg := range tests {
+		if err := sender.Send(msg); err == nil {
+			t.Errorf("Send(%q, %q) succeeded", msg.To, msg.Subject)
+		}
+	}
+}
+
+func TestNewSender(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"file", Config{Driver: DriverFile, MailboxDir: t.TempDir()}, false},
+		{"default is file", Config{MailboxDir: t.TempDir()}, false},
+		{"file without mailbox", Config{Driver: DriverFile}, true},
+		{"smtp", Config{Driver: DriverSMTP, Host: "localhost", Port: 25, Encryption: EncryptionNone}, false},
+		{"smtp without host", Config{Driver: DriverSMTP}, true},
+		{"smtp with unknown encryption", Config{Driver: DriverSMTP, Host: "localhost", Encryption: "ssl3"}, true},
+		{"unknown driver", Config{Driver: "pigeon"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSender(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("NewSender error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	EncryptionNone     = "none"
+	EncryptionStartTLS = "starttls"
+	EncryptionTLS      = "tls"
+
+	smtpTimeout = 10 * time.Second
+)
+
+type SMTPSender struct {
+	host       string
+	port       int
+	username   string
+	password   string
+	from       string
+	encryption string
+}
+
+func NewSMTPSender(cfg Config) (*SMTPSender, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("smtp host is not configured")
+	}
+
+	switch cfg.Encryption {
+	case EncryptionNone, EncryptionStartTLS, EncryptionTLS:
+	default:
+		return nil, fmt.Errorf("unknown smtp encryption %q", cfg.Encryption)
+	}
+
+	return &SMTPSender{
+		host:       cfg.Host,
+		port:       cfg.Port,
+		username:   cfg.Username,
+		password:   cfg.Password,
+		from:       cfg.From,
+		encryption: cfg.Encryption,
+	}, nil
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	body, err := buildMIME(s.from, msg)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.dial()
+	if err != nil {
+		return fmt.Errorf("smtp connect: %w", err)
+	}
+	defer client.Close()
+
+	if s.encryption == EncryptionStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+
+	if s.username != "" {
+		auth := smtp.PlainAuth("", s.username, s.password, s.host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.from); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data close: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func (s *SMTPSender) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	if s.encryption == EncryptionTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: s.host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(smtpTimeout * 3))
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	TemplateTwoFaCode         = "two_fa_code"
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailVerification = "email_verification"
+)
+
+//go:embed templates/*.txt templates/*.html
+var templatesFS embed.FS
+
+// Render builds a message from the "<name>.txt" and "<name>.html" templates.
+// The subject comes from the {{define "subject"}} block of the text template.
+// Each template is parsed on its own so the subject blocks do not collide.
+func Render(name, to string, data interface{}) (Message, error) {
+	textTmpl, err := texttemplate.ParseFS(templatesFS, "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, fmt.Errorf("email template %s: %w", name, err)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+
+	if _, err := fs.Stat(templatesFS, "templates/"+name+".html"); err == nil {
+		htmlTmpl, err := htmltemplate.ParseFS(templatesFS, "templates/"+name+".html")
+		if err != nil {
+			return Message{}, fmt.Errorf("email template %s: %w", name, err)
+		}
+		if err := htmlTmpl.Execute(&html, data); err != nil {
+			return Message{}, err
+		}
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
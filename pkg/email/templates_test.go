@@ -0,0 +1,82 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        map[string]interface{}
+		subject     string
+		textContain []string
+		htmlContain []string
+	}{
+		{
+			TemplateTwoFaCode,
+			map[string]interface{}{"Name": "Иван", "Code": "123456", "TTLMinutes": 5},
+			"Your verification code",
+			[]string{"Hello, Иван!", "123456", "5 minutes"},
+			[]string{"123456"},
+		},
+		{
+			TemplatePasswordReset,
+			map[string]interface{}{"Name": "Иван", "Link": "http://localhost/reset-password?token=abc", "TTLMinutes": 60},
+			"Password reset",
+			[]string{"http://localhost/reset-password?token=abc", "60 minutes"},
+			[]string{`href="http://localhost/reset-password?token=abc"`},
+		},
+		{
+			TemplateEmailVerification,
+			map[string]interface{}{"Name": "Иван", "Link": "http://localhost/verify-email?token=abc", "TTLHours": 24},
+			"Confirm your email address",
+			[]string{"http://localhost/verify-email?token=abc", "24 hours"},
+			[]string{`href="http://localhost/verify-email?token=abc"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Render(tt.name, "ivan@example.com", tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if msg.To != "ivan@example.com" {
+				t.Errorf("To = %q", msg.To)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.subject)
+			}
+			for _, want := range tt.textContain {
+				if !strings.Contains(msg.Text, want) {
+					t.Errorf("text body does not contain %q:\n%s", want, msg.Text)
+				}
+			}
+			for _, want := range tt.htmlContain {
+				if !strings.Contains(msg.HTML, want) {
+					t.Errorf("html body does not contain %q:\n%s", want, msg.HTML)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	msg, err := Render(TemplateTwoFaCode, "ivan@example.com", map[string]interface{}{
+		"Name": "<script>alert(1)</script>", "Code": "123456", "TTLMinutes": 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Errorf("html body is not escaped:\n%s", msg.HTML)
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("missing", "ivan@example.com", nil); err == nil {
+		t.Error("expected an error for an unknown template")
+	}
+}
@@ -0,0 +1,71 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// Object describes a stored file.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps file contents under opaque keys. Open returns a seekable
+// reader so downloads can be served with http.ServeContent and Range
+// requests.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Config selects and configures a backend. Dir is only read by the local
+// driver, the remaining fields only by the s3 driver.
+type Config struct {
+	Driver    string
+	Dir       string
+	Endpoint  string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+}
+
+// New picks the backend configured in cfg.Driver.
+func New(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case DriverLocal, "":
+		return NewLocal(cfg.Dir)
+	case DriverS3:
+		return NewS3(cfg)
+	default:
+		return nil, fmt.Errorf("unknown file storage driver %q", cfg.Driver)
+	}
+}
+
+// validKey rejects keys that could escape the storage root.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid file key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid file key %q", key)
+		}
+	}
+	return nil
+}
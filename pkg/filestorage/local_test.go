@@ -0,0 +1,147 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPutOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const key = "diplomas/1/abc"
+	content := "%PDF-1.4 thesis"
+	if err := storage.Put(ctx, key, strings.NewReader(content), int64(len(content)), "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, obj, err := storage.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("content = %q, want %q", got, content)
+	}
+	if obj.Key != key || obj.Size != int64(len(content)) {
+		t.Errorf("object = %+v", obj)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete error = %v, want ErrNotFound", err)
+	}
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Errorf("second Delete error = %v, want nil", err)
+	}
+}
+
+func TestLocalPutUnknownSize(t *testing.T) {
+	ctx := context.Background()
+	storage, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Put(ctx, "a/b", strings.NewReader("streamed"), -1, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	_, obj, err := storage.Open(ctx, "a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Size != int64(len("streamed")) {
+		t.Errorf("size = %d", obj.Size)
+	}
+}
+
+func TestLocalPutSizeMismatch(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	storage, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Put(ctx, "a/b", strings.NewReader("short"), 100, "text/plain"); err == nil {
+		t.Fatal("expected an error for a size mismatch")
+	}
+	if _, _, err := storage.Open(ctx, "a/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open error = %v, want ErrNotFound", err)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(root, "a", ".upload-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestLocalRejectsTraversal(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	storage, err := NewLocal(filepath.Join(parent, "root"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Put(ctx, "../escaped", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Error("Put accepted a key outside the root")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file written outside the root: %v", err)
+	}
+	if _, _, err := storage.Open(ctx, "../escaped"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Open error = %v, want an invalid key error", err)
+	}
+	if err := storage.Delete(ctx, "../escaped"); err == nil {
+		t.Error("Delete accepted a key outside the root")
+	}
+}
+
+func TestNewLocalRequiresRoot(t *testing.T) {
+	if _, err := NewLocal(""); err == nil {
+		t.Error("expected an error for an empty root")
+	}
+}
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"diplomas/1/abc", true},
+		{"file", true},
+		{"a/b..c/d", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"..", false},
+		{"../secret", false},
+		{"diplomas/../../secret", false},
+		{"diplomas/./abc", false},
+		{"diplomas//abc", false},
+		{"diplomas/abc/", false},
+		{"diplomas\\..\\secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if err := validKey(tt.key); (err == nil) != tt.valid {
+				t.Errorf("validKey(%q) error = %v, want valid %t", tt.key, err, tt.valid)
+			}
+		})
+	}
+}
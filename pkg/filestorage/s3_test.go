@@ -0,0 +1,192 @@
+package filestorage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// newTestS3 connects to the MinIO from docker-compose, for example with
+//
+//	docker compose up -d minio
+//	MINIO_ENDPOINT=localhost:9000 go test ./pkg/filestorage
+//
+// Every test gets a fresh bucket that NewS3 has to create.
+func newTestS3(t *testing.T) *S3 {
+	t.Helper()
+
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT is not set")
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{
+		Driver:    DriverS3,
+		Endpoint:  endpoint,
+		Bucket:    "gosmol-test-" + hex.EncodeToString(b),
+		AccessKey: envOr("MINIO_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("MINIO_SECRET_KEY", "minioadmin"),
+		UseSSL:    os.Getenv("MINIO_USE_SSL") == "true",
+	}
+
+	storage, err := NewS3(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		for object := range storage.client.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{Recursive: true}) {
+			storage.client.RemoveObject(ctx, cfg.Bucket, object.Key, minio.RemoveObjectOptions{})
+		}
+		storage.client.RemoveBucket(ctx, cfg.Bucket)
+	})
+
+	return storage
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func TestS3CreatesBucket(t *testing.T) {
+	storage := newTestS3(t)
+
+	exists, err := storage.client.BucketExists(context.Background(), storage.bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatalf("bucket %s was not created", storage.bucket)
+	}
+
+	// A second backend on the same bucket finds it instead of failing.
+	endpoint := storage.client.EndpointURL()
+	if _, err := NewS3(Config{
+		Endpoint:  endpoint.Host,
+		Bucket:    storage.bucket,
+		AccessKey: envOr("MINIO_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("MINIO_SECRET_KEY", "minioadmin"),
+		UseSSL:    endpoint.Scheme == "https",
+	}); err != nil {
+		t.Errorf("NewS3 on an existing bucket: %v", err)
+	}
+}
+
+func TestS3PutOpenDelete(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestS3(t)
+
+	const key = "diplomas/1/abc"
+	content := "%PDF-1.4 thesis"
+	if err := storage.Put(ctx, key, strings.NewReader(content), -1, "application/pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, obj, err := storage.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if obj.Key != key || obj.Size != int64(len(content)) {
+		t.Errorf("object = %+v", obj)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("content = %q, want %q", got, content)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open after Delete error = %v, want ErrNotFound", err)
+	}
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Errorf("second Delete error = %v, want nil", err)
+	}
+}
+
+func TestS3OpenRange(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestS3(t)
+
+	content := "0123456789abcdefghij"
+	if err := storage.Put(ctx, "range", strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _, err := storage.Open(ctx, "range")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		offset int64
+		whence int
+		length int
+		want   string
+	}{
+		{10, io.SeekStart, 5, "abcde"},
+		{2, io.SeekCurrent, 3, "hij"},
+		{-4, io.SeekEnd, 2, "gh"},
+		{0, io.SeekStart, 4, "0123"},
+	}
+
+	for _, tt := range tests {
+		if _, err := r.Seek(tt.offset, tt.whence); err != nil {
+			t.Fatalf("Seek(%d, %d): %v", tt.offset, tt.whence, err)
+		}
+		buf := make([]byte, tt.length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("read after Seek(%d, %d): %v", tt.offset, tt.whence, err)
+		}
+		if string(buf) != tt.want {
+			t.Errorf("Seek(%d, %d) read %q, want %q", tt.offset, tt.whence, buf, tt.want)
+		}
+	}
+}
+
+func TestS3RejectsInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	storage := newTestS3(t)
+
+	if err := storage.Put(ctx, "../escaped", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Error("Put accepted an invalid key")
+	}
+	if _, _, err := storage.Open(ctx, "/absolute"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Open error = %v, want an invalid key error", err)
+	}
+}
+
+func TestNewS3RequiresEndpointAndBucket(t *testing.T) {
+	tests := []Config{
+		{Bucket: "diplomas"},
+		{Endpoint: "localhost:9000"},
+	}
+	for _, cfg := range tests {
+		if _, err := NewS3(cfg); err == nil {
+			t.Errorf("NewS3(%+v) succeeded", cfg)
+		}
+	}
+}